@@ -0,0 +1,207 @@
+package cache
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Store is a small JSON file backed key-value store in the cache directory
+type Store struct {
+	path    string
+	lock    sync.Mutex
+	entries map[string]json.RawMessage
+	dirty   bool
+}
+
+var stores = make(map[string]*Store)
+var storesLock sync.Mutex
+var lastSave time.Time
+
+// Directory returns the configured cache directory, defaulting to the user cache directory
+func Directory() string {
+	if dir := viper.GetString("cache-dir"); dir != "" {
+		return dir
+	}
+
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ".transcoder-cache"
+	}
+
+	return filepath.Join(dir, "transcoder-go")
+}
+
+// Open loads the named store, stores are shared for the lifetime of the process
+func Open(name string) *Store {
+	storesLock.Lock()
+	defer storesLock.Unlock()
+
+	if store, ok := stores[name]; ok {
+		return store
+	}
+
+	store := &Store{
+		path:    filepath.Join(Directory(), name+".json"),
+		entries: make(map[string]json.RawMessage),
+	}
+
+	data, err := ioutil.ReadFile(store.path)
+	if err != nil && !os.IsNotExist(err) {
+		log.Errorf("Error reading cache %s: %s", store.path, err)
+	}
+
+	if err == nil {
+		if err := json.Unmarshal(data, &store.entries); err != nil {
+			log.Warningf("Discarding corrupted cache %s: %s", store.path, err)
+			store.entries = make(map[string]json.RawMessage)
+		}
+	}
+
+	stores[name] = store
+
+	return store
+}
+
+// SaveAll writes every store with entries that were put but not saved yet
+func SaveAll() {
+	storesLock.Lock()
+	defer storesLock.Unlock()
+
+	saveAll()
+}
+
+// SaveIfDue works like SaveAll, but writes the stores at most once per interval
+func SaveIfDue(interval time.Duration) {
+	storesLock.Lock()
+	defer storesLock.Unlock()
+
+	if time.Since(lastSave) < interval {
+		return
+	}
+
+	saveAll()
+}
+
+func saveAll() {
+	lastSave = time.Now()
+
+	for _, store := range stores {
+		store.lock.Lock()
+		dirty := store.dirty
+		store.lock.Unlock()
+
+		if !dirty {
+			continue
+		}
+
+		if err := store.Save(); err != nil {
+			log.Errorf("Error saving cache %s: %s", store.path, err)
+		}
+	}
+}
+
+// Get decodes the entry into value and reports whether it existed
+func (store *Store) Get(key string, value interface{}) bool {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	raw, ok := store.entries[key]
+	if !ok {
+		return false
+	}
+
+	return json.Unmarshal(raw, value) == nil
+}
+
+// Keys returns the keys of all entries in no particular order
+func (store *Store) Keys() []string {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	keys := make([]string, 0, len(store.entries))
+	for key := range store.entries {
+		keys = append(keys, key)
+	}
+
+	return keys
+}
+
+func (store *Store) Set(key string, value interface{}) error {
+	if err := store.Put(key, value); err != nil {
+		return err
+	}
+
+	return store.Save()
+}
+
+// Put sets the entry without writing the store, for callers that set many entries and save once
+func (store *Store) Put(key string, value interface{}) error {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return errors.Wrap(err, "failed encoding cache entry")
+	}
+
+	store.lock.Lock()
+	store.entries[key] = raw
+	store.dirty = true
+	store.lock.Unlock()
+
+	return nil
+}
+
+func (store *Store) Delete(key string) error {
+	store.lock.Lock()
+	delete(store.entries, key)
+	store.dirty = true
+	store.lock.Unlock()
+
+	return store.Save()
+}
+
+// Save writes the store to a temporary file and renames it, so readers never see a partial file
+func (store *Store) Save() error {
+	store.lock.Lock()
+	data, err := json.Marshal(store.entries)
+	store.dirty = false
+	store.lock.Unlock()
+
+	if err != nil {
+		return errors.Wrap(err, "failed encoding cache")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(store.path), 0755); err != nil {
+		return errors.Wrap(err, "failed creating cache directory")
+	}
+
+	if err := ioutil.WriteFile(store.path+".tmp", data, 0644); err != nil {
+		return errors.Wrap(err, "failed writing cache")
+	}
+
+	return errors.Wrap(os.Rename(store.path+".tmp", store.path), "failed replacing cache")
+}
+
+// FileKey identifies a file by its absolute path, size and modification time, followed by any extra parts
+func FileKey(fileName string, extra ...string) (string, error) {
+	absPath, err := filepath.Abs(fileName)
+	if err != nil {
+		return "", err
+	}
+
+	stat, err := os.Stat(absPath)
+	if err != nil {
+		return "", err
+	}
+
+	parts := append([]string{absPath, strconv.FormatInt(stat.Size(), 10), strconv.FormatInt(stat.ModTime().UnixNano(), 10)}, extra...)
+
+	return strings.Join(parts, "|"), nil
+}
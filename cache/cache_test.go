@@ -0,0 +1,86 @@
+package cache
+
+import (
+	"github.com/spf13/viper"
+	"testing"
+	"time"
+)
+
+func TestSaveIfDue(t *testing.T) {
+	tests := []struct {
+		name      string
+		sinceSave time.Duration
+		put       bool
+		expected  bool
+	}{
+		{name: "never saved", sinceSave: -1, put: true, expected: true},
+		{name: "interval passed", sinceSave: 2 * time.Minute, put: true, expected: true},
+		{name: "saved recently", sinceSave: 10 * time.Second, put: true, expected: false},
+		{name: "nothing put", sinceSave: 2 * time.Minute, put: false, expected: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useTempDirectory(t)
+
+			if test.sinceSave >= 0 {
+				lastSave = time.Now().Add(-test.sinceSave)
+			}
+
+			if test.put {
+				if err := Open("test").Put("key", "value"); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			SaveIfDue(time.Minute)
+
+			// Reopen the store from disk
+			stores = make(map[string]*Store)
+
+			var value string
+			if actual := Open("test").Get("key", &value); actual != test.expected {
+				t.Errorf("expected saved %v, got %v", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestSaveIfDueResetsInterval(t *testing.T) {
+	useTempDirectory(t)
+
+	store := Open("test")
+
+	if err := store.Put("first", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	SaveIfDue(time.Minute)
+
+	if err := store.Put("second", 2); err != nil {
+		t.Fatal(err)
+	}
+
+	SaveIfDue(time.Minute)
+
+	stores = make(map[string]*Store)
+	keys := Open("test").Keys()
+
+	if len(keys) != 1 || keys[0] != "first" {
+		t.Errorf("expected [first], got %v", keys)
+	}
+}
+
+func useTempDirectory(t *testing.T) {
+	t.Helper()
+
+	viper.Set("cache-dir", t.TempDir())
+	stores = make(map[string]*Store)
+	lastSave = time.Time{}
+
+	t.Cleanup(func() {
+		viper.Set("cache-dir", "")
+		stores = make(map[string]*Store)
+		lastSave = time.Time{}
+	})
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package cache
+
+// Inode is not available on this platform
+func Inode(string) (uint64, bool) {
+	return 0, false
+}
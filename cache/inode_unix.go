@@ -0,0 +1,23 @@
+//go:build unix
+
+package cache
+
+import (
+	"os"
+	"syscall"
+)
+
+// Inode returns the inode number of the file
+func Inode(fileName string) (uint64, bool) {
+	stat, err := os.Stat(fileName)
+	if err != nil {
+		return 0, false
+	}
+
+	sys, ok := stat.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+
+	return uint64(sys.Ino), true
+}
@@ -0,0 +1,467 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/Vilsol/transcoder-go/config"
+	"github.com/Vilsol/transcoder-go/transcoder"
+	"github.com/Vilsol/transcoder-go/utils"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cast"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+var configInitForce bool
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Validate, show and create the config file",
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		// Unlike the other commands an invalid config must not stop these
+		setupLogging(cmd)
+	},
+}
+
+var configValidateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Check the config file, environment and flags for unknown options and invalid values",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := config.InitializeConfig(); err != nil {
+			log.Fatal(err)
+		}
+
+		problems := validateConfig(cmd.Root().PersistentFlags())
+		for _, problem := range problems {
+			fmt.Println(problem)
+		}
+
+		if len(problems) > 0 {
+			os.Exit(1)
+		}
+
+		fmt.Println("Configuration is valid")
+	},
+}
+
+var configShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Show the effective configuration and where each value comes from",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := config.InitializeConfig(); err != nil {
+			log.Fatal(err)
+		}
+
+		for _, problem := range validateConfig(cmd.Root().PersistentFlags()) {
+			log.Warning(problem)
+		}
+
+		if err := writeEffectiveConfig(os.Stdout, cmd.Root().PersistentFlags()); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+var configInitCmd = &cobra.Command{
+	Use:   "init [file]",
+	Short: "Write a commented config file template, - for stdout",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		fileName := "config.yaml"
+		if len(args) > 0 {
+			fileName = args[0]
+		}
+
+		if fileName == "-" {
+			if err := writeConfigTemplate(os.Stdout, cmd.Root().PersistentFlags()); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+
+		flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+		if configInitForce {
+			flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		}
+
+		file, err := os.OpenFile(fileName, flags, 0644)
+		if err != nil {
+			if os.IsExist(err) {
+				log.Fatalf("%s already exists, use --force to overwrite it", fileName)
+			}
+			log.Fatal(err)
+		}
+
+		defer file.Close()
+
+		if err := writeConfigTemplate(file, cmd.Root().PersistentFlags()); err != nil {
+			log.Fatal(err)
+		}
+
+		log.Infof("Wrote %s", fileName)
+	},
+}
+
+func init() {
+	configInitCmd.Flags().BoolVar(&configInitForce, "force", false, "Overwrite an existing file")
+
+	configCmd.AddCommand(configValidateCmd)
+	configCmd.AddCommand(configShowCmd)
+	configCmd.AddCommand(configInitCmd)
+	rootCmd.AddCommand(configCmd)
+}
+
+// commandLineOnly flags have no meaning in the config file
+var commandLineOnly = map[string]bool{
+	"log":        true,
+	"log-format": true,
+	"colors":     true,
+	"config":     true,
+}
+
+// optionChecks validate option values beyond their type
+var optionChecks = map[string]func(value string) error{
+	"container": func(value string) error {
+		_, err := transcoder.GetContainer(value)
+		return err
+	},
+	"early-exit-predictor": func(value string) error {
+		_, err := transcoder.NewEarlyExitPredictor(value)
+		return err
+	},
+	"dolby-vision":         oneOf("skip", "strip", "passthrough"),
+	"deinterlace":          oneOf("off", "auto", "always"),
+	"mirror-fallback":      oneOf("link", "copy", "skip"),
+	"learned-prediction":   oneOf("off", "skip", "deprioritize"),
+	"chunk-split":          oneOf("keyframes", "scene"),
+	"job-logs":             oneOf("off", "failed", "all"),
+	"ffmpeg-log-level":     oneOf("quiet", "panic", "fatal", "error", "warning", "info", "verbose", "debug", "trace"),
+	"tonemap-algorithm":    oneOf("none", "clip", "linear", "gamma", "reinhard", "hable", "mobius"),
+	"min-size":             humanSize,
+	"max-size":             humanSize,
+	"max-bitrate":          humanSize,
+	"target-bitrate":       humanSize,
+	"target-size-per-hour": humanSize,
+}
+
+var typeDescriptions = map[string]string{
+	"bool":        "true or false",
+	"int":         "an integer",
+	"float64":     "a number",
+	"duration":    "a duration such as 90s or 1h30m",
+	"stringSlice": "a list of strings",
+	"intSlice":    "a list of integers",
+	"string":      "a string",
+}
+
+func oneOf(values ...string) func(string) error {
+	return func(value string) error {
+		for _, allowed := range values {
+			if value == allowed {
+				return nil
+			}
+		}
+
+		return errors.Errorf("must be one of %s, got %s", strings.Join(values, ", "), value)
+	}
+}
+
+func humanSize(value string) error {
+	if value == "" {
+		return nil
+	}
+
+	_, err := utils.ParseHumanSize(value)
+	return err
+}
+
+// optionTypes maps every option that may appear in the config file to its flag type
+func optionTypes(flags *pflag.FlagSet) map[string]string {
+	types := map[string]string{
+		"paths": "stringSlice",
+	}
+
+	flags.VisitAll(func(flag *pflag.Flag) {
+		if !commandLineOnly[flag.Name] {
+			types[flag.Name] = flag.Value.Type()
+		}
+	})
+
+	return types
+}
+
+// validateConfig returns every problem of the config file, its profiles, the environment and the flags
+func validateConfig(flags *pflag.FlagSet) []string {
+	types := optionTypes(flags)
+	problems := make([]string, 0)
+
+	if fileName := viper.ConfigFileUsed(); fileName != "" {
+		problems = append(problems, validateConfigFile(fileName, types)...)
+	}
+
+	keys := sortedKeys(types)
+
+	for _, key := range keys {
+		source := optionSource(flags, key)
+		if source != "flag" && source != "environment" {
+			// Defaults are valid and file values were checked above
+			continue
+		}
+
+		if err := validateOption(key, types[key], viper.Get(key)); err != nil {
+			problems = append(problems, fmt.Sprintf("%s %s: %s", source, key, err))
+		}
+	}
+
+	if len(viper.GetStringSlice("streams")) > 0 && transcoder.MapsStreams(viper.GetString("flags")) {
+		problems = append(problems, "streams: stream rules are not applied when flags map streams with -map")
+	}
+
+	return problems
+}
+
+func validateConfigFile(fileName string, types map[string]string) []string {
+	problems := make([]string, 0)
+
+	file := viper.New()
+	file.SetConfigFile(fileName)
+
+	if err := file.ReadInConfig(); err != nil {
+		return append(problems, err.Error())
+	}
+
+	keys := file.AllKeys()
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		option := key
+		context := fileName
+
+		if strings.HasPrefix(key, "profiles.") {
+			parts := strings.SplitN(key, ".", 3)
+			if len(parts) < 3 {
+				problems = append(problems, fmt.Sprintf("%s: profile %s must contain options", fileName, strings.TrimPrefix(key, "profiles.")))
+				continue
+			}
+
+			option = parts[2]
+			context = fmt.Sprintf("%s profile %s", fileName, parts[1])
+
+			if option == "profile" {
+				problems = append(problems, fmt.Sprintf("%s: profiles cannot select another profile", context))
+				continue
+			}
+		}
+
+		kind, ok := types[option]
+		if !ok {
+			problem := fmt.Sprintf("%s: unknown option %s", context, option)
+			if commandLineOnly[option] {
+				problem += ", it can only be given as flag"
+			} else if suggestion := closestOption(option, types); suggestion != "" {
+				problem += fmt.Sprintf(", did you mean %s?", suggestion)
+			}
+
+			problems = append(problems, problem)
+			continue
+		}
+
+		if err := validateOption(option, kind, file.Get(key)); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %s %s", context, option, err))
+		}
+	}
+
+	return problems
+}
+
+func validateOption(key string, kind string, value interface{}) error {
+	var err error
+
+	switch kind {
+	case "bool":
+		_, err = cast.ToBoolE(value)
+	case "int":
+		_, err = cast.ToIntE(value)
+	case "float64":
+		_, err = cast.ToFloat64E(value)
+	case "duration":
+		_, err = cast.ToDurationE(value)
+	case "stringSlice":
+		_, err = cast.ToStringSliceE(value)
+	case "intSlice":
+		_, err = cast.ToIntSliceE(value)
+	default:
+		_, err = cast.ToStringE(value)
+	}
+
+	if err != nil {
+		return errors.Errorf("must be %s, got %v", typeDescriptions[kind], value)
+	}
+
+	if check, ok := optionChecks[key]; ok {
+		return check(cast.ToString(value))
+	}
+
+	return nil
+}
+
+// closestOption suggests the known option with the smallest edit distance to a misspelled one
+func closestOption(option string, types map[string]string) string {
+	best := ""
+	bestDistance := len(option)/3 + 2
+
+	for _, known := range sortedKeys(types) {
+		if distance := editDistance(option, known); distance < bestDistance {
+			best = known
+			bestDistance = distance
+		}
+	}
+
+	return best
+}
+
+func editDistance(a string, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+
+			current[j] = previous[j-1] + cost
+			if previous[j]+1 < current[j] {
+				current[j] = previous[j] + 1
+			}
+			if current[j-1]+1 < current[j] {
+				current[j] = current[j-1] + 1
+			}
+		}
+
+		previous, current = current, previous
+	}
+
+	return previous[len(b)]
+}
+
+// optionSource names where the effective value of an option comes from
+func optionSource(flags *pflag.FlagSet, key string) string {
+	if source := config.SecretSource(key); source != "" {
+		return source
+	}
+
+	if flag := flags.Lookup(key); flag != nil && flag.Changed {
+		return "flag"
+	}
+
+	if _, ok := os.LookupEnv(strings.ToUpper(key)); ok {
+		return "environment"
+	}
+
+	if profile := viper.GetString("profile"); profile != "" && viper.IsSet("profiles."+profile+"."+key) {
+		return "profile " + profile
+	}
+
+	if viper.InConfig(key) {
+		return "config file"
+	}
+
+	return "default"
+}
+
+func writeEffectiveConfig(out io.Writer, flags *pflag.FlagSet) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+
+	fileName := viper.ConfigFileUsed()
+	if fileName == "" {
+		fileName = "none"
+	}
+
+	fmt.Fprintf(w, "Config file:\t%s\n\n", fileName)
+	fmt.Fprintln(w, "Option\tValue\tSource")
+
+	for _, key := range sortedKeys(optionTypes(flags)) {
+		fmt.Fprintf(w, "%s\t%v\t%s\n", key, config.RedactValue(key, viper.Get(key)), optionSource(flags, key))
+	}
+
+	return w.Flush()
+}
+
+func writeConfigTemplate(out io.Writer, flags *pflag.FlagSet) error {
+	var b strings.Builder
+
+	b.WriteString("# transcoder config file\n")
+	b.WriteString("# Every option can also be given as flag or as upper case environment variable, both take precedence over this file.\n")
+	b.WriteString("# Uncomment the options you want to change.\n\n")
+	b.WriteString("# Paths searched when none are given on the command line\n")
+	b.WriteString("# paths: []\n")
+
+	flags.VisitAll(func(flag *pflag.Flag) {
+		if commandLineOnly[flag.Name] {
+			return
+		}
+
+		b.WriteString(fmt.Sprintf("\n# %s\n# %s: %s\n", flag.Usage, flag.Name, templateValue(flag)))
+	})
+
+	b.WriteString("\n# Named groups of options, selected with --profile\n")
+	b.WriteString("# profiles:\n")
+	b.WriteString("#   mobile:\n")
+	b.WriteString("#     container: mp4\n")
+	b.WriteString("#     output: \"{dir}/{stem}.mobile.{ext}\"\n")
+
+	_, err := io.WriteString(out, b.String())
+	return err
+}
+
+// templateValue formats the default of a flag as YAML
+func templateValue(flag *pflag.Flag) string {
+	switch flag.Value.Type() {
+	case "string":
+		return strconv.Quote(flag.DefValue)
+	case "stringSlice", "intSlice":
+		values := strings.Trim(flag.DefValue, "[]")
+		if values == "" {
+			return "[]"
+		}
+
+		items := strings.Split(values, ",")
+		if flag.Value.Type() == "stringSlice" {
+			for i, item := range items {
+				items[i] = strconv.Quote(item)
+			}
+		}
+
+		return "[" + strings.Join(items, ", ") + "]"
+	}
+
+	return flag.DefValue
+}
+
+func sortedKeys(types map[string]string) []string {
+	keys := make([]string, 0, len(types))
+	for key := range types {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,248 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/Vilsol/transcoder-go/config"
+	"github.com/Vilsol/transcoder-go/transcoder"
+	"github.com/Vilsol/transcoder-go/utils"
+	"github.com/bmatcuk/doublestar/v4"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const ignoreFileName = ".transcoderignore"
+
+// fileFilter decides which of the collected files are left alone before shouldTranscode is consulted
+type fileFilter struct {
+	exclude []string
+
+	minSize int64
+	maxSize int64
+
+	minDuration time.Duration
+	maxDuration time.Duration
+
+	minAge time.Duration
+
+	// Patterns of the .transcoderignore file and directory config of each directory, nil if there are none
+	ignoreFiles map[string][]string
+}
+
+func newFileFilter() (*fileFilter, error) {
+	filter := &fileFilter{
+		minDuration: viper.GetDuration("min-duration"),
+		maxDuration: viper.GetDuration("max-duration"),
+		minAge:      viper.GetDuration("min-age"),
+		ignoreFiles: make(map[string][]string),
+	}
+
+	for _, pattern := range viper.GetStringSlice("exclude") {
+		if !doublestar.ValidatePattern(pattern) {
+			return nil, fmt.Errorf("invalid exclude pattern: %s", pattern)
+		}
+
+		filter.exclude = append(filter.exclude, expandPattern(pattern))
+	}
+
+	var err error
+
+	if value := viper.GetString("min-size"); value != "" {
+		if filter.minSize, err = utils.ParseHumanSize(value); err != nil {
+			return nil, fmt.Errorf("invalid minimum size %s: %s", value, err)
+		}
+	}
+
+	if value := viper.GetString("max-size"); value != "" {
+		if filter.maxSize, err = utils.ParseHumanSize(value); err != nil {
+			return nil, fmt.Errorf("invalid maximum size %s: %s", value, err)
+		}
+	}
+
+	return filter, nil
+}
+
+// excluded returns why the file should not be touched, or an empty string if it may be transcoded
+func (f *fileFilter) excluded(file inputFile) string {
+	if !hasTranscodedExtension(file.Path) {
+		// Skipped by shouldTranscode anyway
+		return ""
+	}
+
+	relPath, err := filepath.Rel(file.BasePath, file.Path)
+	if err != nil {
+		relPath = file.Path
+	}
+
+	relPath = filepath.ToSlash(relPath)
+
+	for _, pattern := range f.exclude {
+		if matchPath(pattern, relPath) || matchPath(pattern, filepath.ToSlash(file.Path)) {
+			return "matches exclude pattern " + pattern
+		}
+	}
+
+	if reason := f.ignored(file); reason != "" {
+		return reason
+	}
+
+	if f.minSize > 0 || f.maxSize > 0 || f.minAge > 0 {
+		stat, err := os.Stat(file.Path)
+		if err != nil {
+			return err.Error()
+		}
+
+		if f.minSize > 0 && stat.Size() < f.minSize {
+			return "smaller than " + utils.BytesHumanReadable(f.minSize)
+		}
+
+		if f.maxSize > 0 && stat.Size() > f.maxSize {
+			return "larger than " + utils.BytesHumanReadable(f.maxSize)
+		}
+
+		if f.minAge > 0 && time.Since(stat.ModTime()) < f.minAge {
+			return "modified less than " + f.minAge.String() + " ago"
+		}
+	}
+
+	if f.minDuration > 0 || f.maxDuration > 0 {
+		metadata, err := transcoder.ReadFileMetadata(file.Path)
+		if err != nil {
+			return "failed reading metadata: " + err.Error()
+		}
+
+		seconds, err := strconv.ParseFloat(metadata.Format.Duration, 64)
+		if err != nil {
+			return "unknown duration"
+		}
+
+		duration := time.Duration(seconds * float64(time.Second))
+
+		if f.minDuration > 0 && duration < f.minDuration {
+			return "shorter than " + f.minDuration.String()
+		}
+
+		if f.maxDuration > 0 && duration > f.maxDuration {
+			return "longer than " + f.maxDuration.String()
+		}
+	}
+
+	return ""
+}
+
+// ignored checks the .transcoderignore files and directory config exclusions from the directory of the file up to the
+// filesystem root, like the directory configs are applied. Patterns are relative to the directory of the file that contains them.
+func (f *fileFilter) ignored(file inputFile) string {
+	dir := filepath.Dir(file.Path)
+
+	for {
+		for _, pattern := range f.ignorePatterns(dir) {
+			relPath, err := filepath.Rel(dir, file.Path)
+			if err != nil {
+				break
+			}
+
+			if matchPath(pattern, filepath.ToSlash(relPath)) {
+				return "matches pattern " + pattern + " of " + dir
+			}
+		}
+
+		if dir == filepath.Dir(dir) {
+			return ""
+		}
+
+		dir = filepath.Dir(dir)
+	}
+}
+
+func (f *fileFilter) ignorePatterns(dir string) []string {
+	if patterns, ok := f.ignoreFiles[dir]; ok {
+		return patterns
+	}
+
+	patterns, err := readIgnoreFile(filepath.Join(dir, ignoreFileName))
+	if err != nil && !os.IsNotExist(err) {
+		log.Errorf("Error reading ignore file %s: %s", filepath.Join(dir, ignoreFileName), err)
+	}
+
+	// Errors of directory configs are reported when they are applied
+	if directory, _ := config.Directory(dir); directory != nil {
+		for _, pattern := range directory.Exclude {
+			if !doublestar.ValidatePattern(pattern) {
+				log.Warningf("Invalid exclude pattern in %s: %s", directory.Path, pattern)
+				continue
+			}
+
+			patterns = append(patterns, relativePattern(pattern))
+		}
+	}
+
+	f.ignoreFiles[dir] = patterns
+
+	return patterns
+}
+
+// readIgnoreFile reads one pattern per line, empty lines and lines starting with # are skipped
+func readIgnoreFile(fileName string) ([]string, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	patterns := make([]string, 0)
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if !doublestar.ValidatePattern(line) {
+			log.Warningf("Invalid pattern in %s: %s", fileName, line)
+			continue
+		}
+
+		patterns = append(patterns, relativePattern(line))
+	}
+
+	return patterns, scanner.Err()
+}
+
+// relativePattern expands a pattern that is relative to the directory it was configured in.
+// Like .gitignore, a leading slash anchors the pattern to that directory.
+func relativePattern(pattern string) string {
+	if strings.HasPrefix(pattern, "/") {
+		return strings.TrimPrefix(pattern, "/")
+	}
+
+	return expandPattern(pattern)
+}
+
+// expandPattern lets patterns without a slash, other than a trailing one, match a file or directory name at any depth
+func expandPattern(pattern string) string {
+	if !strings.Contains(strings.TrimSuffix(pattern, "/"), "/") {
+		return "**/" + pattern
+	}
+
+	return pattern
+}
+
+// matchPath matches the pattern against the whole path
+func matchPath(pattern string, path string) bool {
+	if matched, _ := doublestar.Match(pattern, path); matched {
+		return true
+	}
+
+	// A pattern matching a directory excludes everything below it
+	matched, _ := doublestar.Match(strings.TrimSuffix(pattern, "/")+"/**", path)
+
+	return matched
+}
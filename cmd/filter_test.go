@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestMatchPath(t *testing.T) {
+	tests := []struct {
+		name     string
+		pattern  string
+		path     string
+		expected bool
+	}{
+		{name: "file name at any depth", pattern: "*.sample.mkv", path: "show/season 1/a.sample.mkv", expected: true},
+		{name: "file name mismatch", pattern: "*.sample.mkv", path: "show/season 1/a.mkv", expected: false},
+		{name: "directory name at any depth", pattern: "extras", path: "show/extras/a.mkv", expected: true},
+		{name: "directory with trailing slash", pattern: "extras/", path: "show/extras/b/a.mkv", expected: true},
+		{name: "name is not a prefix", pattern: "extras", path: "show/extras2/a.mkv", expected: false},
+		{name: "anchored to the directory", pattern: "/extras", path: "extras/a.mkv", expected: true},
+		{name: "anchored pattern below the directory", pattern: "/extras", path: "show/extras/a.mkv", expected: false},
+		{name: "relative path with a slash", pattern: "show/*.mkv", path: "show/a.mkv", expected: true},
+		{name: "relative path with a slash is anchored", pattern: "show/*.mkv", path: "tv/show/a.mkv", expected: false},
+		{name: "double star", pattern: "show/**/*.mkv", path: "show/season 1/a.mkv", expected: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := matchPath(relativePattern(test.pattern), test.path); actual != test.expected {
+				t.Errorf("expected %v, got %v", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestReadIgnoreFile(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), ignoreFileName)
+
+	content := "# samples\n*.sample.mkv\n\n  /extras  \n[invalid\n"
+	if err := ioutil.WriteFile(fileName, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	patterns, err := readIgnoreFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"**/*.sample.mkv", "extras"}
+	if len(patterns) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, patterns)
+	}
+
+	for i := range expected {
+		if patterns[i] != expected[i] {
+			t.Errorf("expected %v, got %v", expected, patterns)
+			break
+		}
+	}
+}
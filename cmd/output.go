@@ -0,0 +1,149 @@
+package cmd
+
+import (
+	"github.com/Vilsol/transcoder-go/transcoder"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type inputFile struct {
+	Path     string
+	BasePath string
+}
+
+// outputFileName resolves the output template for a file that was found under basePath
+func outputFileName(fileName string, basePath string) string {
+	stem := strings.TrimSuffix(filepath.Base(fileName), filepath.Ext(fileName))
+
+	relDir, err := filepath.Rel(basePath, filepath.Dir(fileName))
+	if err != nil {
+		relDir = "."
+	}
+
+	template := viper.GetString("output")
+	if mirror := viper.GetString("mirror"); mirror != "" {
+		// Mirror mode keeps the file naming of the template but always writes below the mirror root
+		template = filepath.Join(mirror, "{reldir}", filepath.Base(template))
+	}
+
+	replacer := strings.NewReplacer(
+		"{dir}", filepath.Dir(fileName),
+		"{reldir}", relDir,
+		"{stem}", stem,
+		"{name}", filepath.Base(fileName),
+		"{ext}", strings.TrimPrefix(transcoder.OutputContainer().Extension, "."),
+	)
+
+	// Relative mirrors and templates resolve against the working directory, the result log records absolute paths
+	outputName, err := filepath.Abs(replacer.Replace(template))
+	if err != nil {
+		return filepath.Clean(replacer.Replace(template))
+	}
+
+	return outputName
+}
+
+// isWithin reports whether path is dir itself or below it
+func isWithin(dir string, path string) bool {
+	relPath, err := filepath.Rel(dir, path)
+	return err == nil && relPath != ".." && !strings.HasPrefix(relPath, ".."+string(filepath.Separator))
+}
+
+// transcodeTempName is the file ffmpeg writes to before it replaces the output
+func transcodeTempName(outputFileName string) string {
+	return outputFileName + ".transcode-temp"
+}
+
+func processedFileName(outputFileName string) string {
+	return filepath.Dir(outputFileName) + "/." + filepath.Base(outputFileName) + ".processed"
+}
+
+// processedPath is the inverse of processedFileName
+func processedPath(processedFileName string) string {
+	base := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(processedFileName), "."), ".processed")
+	return filepath.Join(filepath.Dir(processedFileName), base)
+}
+
+// replacesOriginal is true when the output only differs from the original by its extension
+func replacesOriginal(fileName string, outputFileName string) bool {
+	if viper.GetString("mirror") != "" {
+		return false
+	}
+
+	return strings.TrimSuffix(fileName, filepath.Ext(fileName)) == strings.TrimSuffix(outputFileName, filepath.Ext(outputFileName))
+}
+
+// mirrorOriginal places the untouched original in the mirror when no transcoded copy is written
+func mirrorOriginal(fileName string, outputFileName string) {
+	if viper.GetString("mirror") == "" {
+		return
+	}
+
+	target := filepath.Join(filepath.Dir(outputFileName), filepath.Base(fileName))
+	if target == fileName {
+		return
+	}
+
+	fallback := viper.GetString("mirror-fallback")
+	if fallback == "skip" {
+		return
+	}
+
+	// Originals may be kept before the output directory was created
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		log.Errorf("Error creating mirror directory for %s: %s", target, err)
+		return
+	}
+
+	err := os.Remove(target)
+
+	if err != nil && !os.IsNotExist(err) {
+		log.Errorf("Error deleting file %s: %s", target, err)
+		return
+	}
+
+	if fallback == "link" {
+		err := os.Link(fileName, target)
+
+		if err == nil {
+			log.Infof("Hard-linked original %s to %s", fileName, target)
+			return
+		}
+
+		log.Debugf("Failed hard-linking %s, copying instead: %s", fileName, err)
+	}
+
+	err = copyFile(fileName, target)
+
+	if err != nil {
+		log.Errorf("Error copying file %s to %s: %s", fileName, target, err)
+		_ = os.Remove(target)
+		return
+	}
+
+	log.Infof("Copied original %s to %s", fileName, target)
+}
+
+func copyFile(source string, target string) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+
+	return out.Close()
+}
@@ -0,0 +1,240 @@
+package cmd
+
+import (
+	"encoding/json"
+	"github.com/Vilsol/transcoder-go/cache"
+	"github.com/Vilsol/transcoder-go/transcoder"
+	"github.com/Vilsol/transcoder-go/utils"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// processedRecord is stored in the processed sidecar next to every output.
+// Sidecars of older versions only contain the size.
+type processedRecord struct {
+	Size        int64  `json:"size"`
+	ModTime     int64  `json:"mtime"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+// processedIndexEntry remembers where a fingerprint was processed, so moved and renamed files are recognized
+type processedIndexEntry struct {
+	Path string `json:"path"`
+}
+
+func shouldTranscode(fileName string, processedFileName string) bool {
+	if terminated {
+		return false
+	}
+
+	if !hasTranscodedExtension(fileName) {
+		return false
+	}
+
+	stat, err := os.Stat(processedFileName)
+
+	if err != nil && !os.IsNotExist(err) {
+		log.Errorf("Error reading file %s: %s", processedFileName, err)
+		return false
+	}
+
+	if stat == nil {
+		if isStamped(fileName) {
+			// File was produced by transcoder, but its processed file was lost
+			log.Infof("Already transcoded according to %s tag: %s", viper.GetString("stamp-tag"), fileName)
+			updateProcessedFile(fileName, processedFileName)
+			return false
+		}
+
+		if previous := processedElsewhere(fileName); previous != "" {
+			log.Infof("Already processed as %s: %s", previous, fileName)
+			updateProcessedFile(fileName, processedFileName)
+			return false
+		}
+
+		// File not transcoded ever
+		return true
+	}
+
+	record, err := readProcessedRecord(processedFileName)
+
+	if err != nil {
+		log.Errorf("Error reading file %s: %s", processedFileName, err)
+		return false
+	}
+
+	if record.Size == 0 {
+		// File processed using old transcoder, update meta file and skip
+		log.Warningf("Updating processed file with file size from old transcoder: %s", fileName)
+		updateProcessedFile(fileName, processedFileName)
+		return false
+	}
+
+	originalStat, err := os.Stat(fileName)
+
+	if err != nil {
+		log.Errorf("Error reading file %s: %s", fileName, err)
+		return false
+	}
+
+	if record.Size != originalStat.Size() {
+		return deleteProcessedFile(processedFileName)
+	}
+
+	if record.Fingerprint == "" {
+		// Sizes matched for sidecars without a fingerprint, add one for the next run
+		updateProcessedFile(fileName, processedFileName)
+		return false
+	}
+
+	if record.ModTime == originalStat.ModTime().UnixNano() {
+		return false
+	}
+
+	fingerprint, err := utils.Fingerprint(fileName)
+
+	if err != nil {
+		log.Errorf("Error fingerprinting file %s: %s", fileName, err)
+		return false
+	}
+
+	if fingerprint == record.Fingerprint {
+		// Only touched, remember the new modification time
+		updateProcessedFile(fileName, processedFileName)
+		return false
+	}
+
+	log.Infof("Processed record belongs to a different file of the same size: %s", fileName)
+
+	return deleteProcessedFile(processedFileName)
+}
+
+// isStamped reports whether the file was produced by transcoder, based on its cached metadata
+func isStamped(fileName string) bool {
+	metadata, err := transcoder.ReadFileMetadata(fileName)
+	if err != nil {
+		log.Debugf("failed reading metadata: %s", err)
+		return false
+	}
+
+	return transcoder.IsStamped(metadata)
+}
+
+func readProcessedRecord(processedFileName string) (*processedRecord, error) {
+	processedData, err := ioutil.ReadFile(processedFileName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	processedData = []byte(strings.TrimSpace(string(processedData)))
+
+	if len(processedData) == 0 {
+		return &processedRecord{}, nil
+	}
+
+	record := &processedRecord{}
+
+	if processedData[0] == '{' {
+		return record, json.Unmarshal(processedData, record)
+	}
+
+	record.Size, err = strconv.ParseInt(string(processedData), 10, 64)
+
+	return record, err
+}
+
+// processedElsewhere returns the path a file with the same fingerprint was processed at, if it was a different one
+func processedElsewhere(fileName string) string {
+	fingerprint, err := utils.Fingerprint(fileName)
+
+	if err != nil {
+		log.Errorf("Error fingerprinting file %s: %s", fileName, err)
+		return ""
+	}
+
+	var entry processedIndexEntry
+	if !cache.Open("processed").Get(fingerprint, &entry) {
+		return ""
+	}
+
+	absPath, err := filepath.Abs(fileName)
+	if err != nil || entry.Path == absPath {
+		// A removed sidecar at the same path asks for the file to be processed again
+		return ""
+	}
+
+	return entry.Path
+}
+
+// updateProcessedFile records fileName, which is the original or the output about to replace it, as processed
+func updateProcessedFile(fileName string, processedFileName string) {
+	if !deleteProcessedFile(processedFileName) {
+		return
+	}
+
+	originalStat, err := os.Stat(fileName)
+
+	if os.IsNotExist(err) {
+		return
+	}
+
+	if err != nil {
+		log.Errorf("Error reading file %s: %s", fileName, err)
+		return
+	}
+
+	record := processedRecord{
+		Size:    originalStat.Size(),
+		ModTime: originalStat.ModTime().UnixNano(),
+	}
+
+	record.Fingerprint, err = utils.Fingerprint(fileName)
+
+	if err != nil {
+		log.Errorf("Error fingerprinting file %s: %s", fileName, err)
+	}
+
+	data, err := json.Marshal(record)
+
+	if err != nil {
+		log.Errorf("Error encoding processed record of %s: %s", fileName, err)
+		return
+	}
+
+	err = ioutil.WriteFile(processedFileName, data, 0644)
+
+	if err != nil {
+		log.Errorf("Error writing file %s: %s", processedFileName, err)
+		return
+	}
+
+	if record.Fingerprint != "" {
+		// The index points at the fingerprinted file, which is the source unless fileName is the temporary output
+		indexedPath, err := filepath.Abs(fileName)
+		if err != nil || fileName == transcodeTempName(processedPath(processedFileName)) {
+			indexedPath = processedPath(processedFileName)
+		}
+
+		entry := processedIndexEntry{Path: indexedPath}
+		if err := cache.Open("processed").Put(record.Fingerprint, entry); err != nil {
+			log.Errorf("Error indexing processed file %s: %s", fileName, err)
+		}
+	}
+}
+
+func deleteProcessedFile(processedFileName string) bool {
+	err := os.Remove(processedFileName)
+
+	if err != nil && !os.IsNotExist(err) {
+		log.Errorf("Error deleting file %s: %s", processedFileName, err)
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"github.com/Vilsol/transcoder-go/cache"
+	"github.com/Vilsol/transcoder-go/models"
+	"github.com/Vilsol/transcoder-go/report"
+	"github.com/Vilsol/transcoder-go/stats"
+	"github.com/Vilsol/transcoder-go/transcoder"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var reportFormat string
+var reportWorst int
+
+var reportCmd = &cobra.Command{
+	Use:   "report [path] ...",
+	Short: "Summarize savings and results of past runs",
+	Long: "Summarize savings and results of past runs from the result log. " +
+		"Processed files under the given paths that predate the result log are added from their sidecars.",
+	Run: func(cmd *cobra.Command, args []string) {
+		entries, err := report.ReadLog()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		known := make(map[string]bool)
+		for _, entry := range entries {
+			// Sidecars are named after the output, which differs from the input for changed extensions, copies and mirrors
+			known[entry.Path] = true
+			if entry.Output != "" {
+				known[entry.Output] = true
+			}
+		}
+
+		for _, path := range args {
+			entries = append(entries, legacyEntries(path, known)...)
+		}
+
+		cache.SaveAll()
+
+		if err := report.Write(os.Stdout, report.Summarize(entries, reportWorst), reportFormat); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	reportCmd.Flags().StringVar(&reportFormat, "format", "table", "Output format (table, json, csv)")
+	reportCmd.Flags().IntVar(&reportWorst, "worst", 10, "Number of worst offenders listed")
+
+	rootCmd.AddCommand(reportCmd)
+}
+
+// legacyEntries reconstructs results of runs without a result log from processed sidecars and ffprobe
+func legacyEntries(root string, known map[string]bool) []report.Entry {
+	entries := make([]report.Entry, 0)
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			log.Warningf("Error reading %s: %s", path, err)
+			return nil
+		}
+
+		name := d.Name()
+		if d.IsDir() || !strings.HasPrefix(name, ".") || !strings.HasSuffix(name, ".processed") {
+			return nil
+		}
+
+		fileName, err := filepath.Abs(processedPath(path))
+		if err != nil || known[fileName] {
+			return nil
+		}
+
+		stat, err := os.Stat(fileName)
+		if err != nil {
+			return nil
+		}
+
+		// Files stamped by the transcoder are outputs, older outputs carry no stamp and cannot be told from kept originals
+		result := models.ResultUnknown
+		duration := 0.0
+		if metadata, err := transcoder.ReadFileMetadata(fileName); err == nil {
+			duration, _ = strconv.ParseFloat(metadata.Format.Duration, 64)
+			if transcoder.IsStamped(metadata) {
+				result = models.ResultReplaced
+			}
+		}
+
+		entries = append(entries, report.Entry{
+			Time:       stat.ModTime(),
+			Path:       fileName,
+			Result:     result,
+			OutputSize: stat.Size(),
+			Duration:   duration,
+			Legacy:     true,
+		})
+
+		return nil
+	})
+
+	if err != nil {
+		log.Errorf("Error walking %s: %s", root, err)
+	}
+
+	return entries
+}
+
+// recordKept writes the result of a job that kept the original without encoding, outputSize is its predicted size if known
+func recordKept(metadata *models.FileMetadata, outputName string, outputSize int64) {
+	if viper.GetBool("no-result-log") {
+		return
+	}
+
+	duration, _ := strconv.ParseFloat(metadata.Format.Duration, 64)
+
+	entry := report.Entry{
+		Time:       time.Now(),
+		Path:       metadata.Format.Filename,
+		Output:     outputName,
+		Profile:    stats.Profile(),
+		Result:     models.ResultKeepOriginal,
+		InputSize:  metadata.Format.SizeInt(),
+		OutputSize: outputSize,
+		Duration:   duration,
+	}
+
+	if err := report.Append(entry); err != nil {
+		log.Errorf("Error writing result log: %s", err)
+	}
+}
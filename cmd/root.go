@@ -1,56 +1,59 @@
 package cmd
 
 import (
+	"github.com/Vilsol/transcoder-go/cache"
 	"github.com/Vilsol/transcoder-go/config"
+	"github.com/Vilsol/transcoder-go/joblog"
 	"github.com/Vilsol/transcoder-go/models"
 	"github.com/Vilsol/transcoder-go/notifications"
+	"github.com/Vilsol/transcoder-go/stats"
 	"github.com/Vilsol/transcoder-go/transcoder"
 	"github.com/Vilsol/transcoder-go/utils"
 	"github.com/bmatcuk/doublestar/v4"
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
-	"io/ioutil"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
 	"os/signal"
 	"path/filepath"
-	"strconv"
-	"strings"
 	"syscall"
+	"time"
 )
 
-// TODO Make Configurable
-const outputFileExtension = ".mkv"
+// cacheSaveInterval limits how often the caches are written between jobs, the metadata cache grows with the library
+const cacheSaveInterval = 5 * time.Minute
 
 var terminated bool
 
 var LogLevel string
 var ForceColors bool
+var LogFormat string
 
 var rootCmd = &cobra.Command{
 	Use: "transcoder [flags] <path> ...",
 
 	Short: "transcoder is an opinionated wrapper around ffmpeg",
+	Args:  cobra.ArbitraryArgs,
 	PersistentPreRun: func(cmd *cobra.Command, args []string) {
-		level, err := log.ParseLevel(LogLevel)
+		setupLogging(cmd)
 
-		if err != nil {
-			panic(err)
+		if err := config.InitializeConfig(); err != nil {
+			log.Fatal(err)
 		}
 
-		log.SetFormatter(&log.TextFormatter{
-			ForceColors: ForceColors,
-		})
-		log.SetOutput(os.Stdout)
-		log.SetLevel(level)
+		if problems := validateConfig(cmd.Root().PersistentFlags()); len(problems) > 0 {
+			for _, problem := range problems {
+				log.Error(problem)
+			}
 
-		config.InitializeConfig()
-		notifications.InitializeNotifications()
+			log.Fatal("Invalid configuration, see transcoder config validate")
+		}
 	},
 	Run: func(cmd *cobra.Command, args []string) {
-		fileList := make([]string, 0)
+		notifications.InitializeNotifications()
 
 		if len(args) == 0 {
 			args = viper.GetStringSlice("paths")
@@ -61,22 +64,21 @@ var rootCmd = &cobra.Command{
 			return
 		}
 
-		for _, arg := range args {
-			realBasePath, pattern := doublestar.SplitPattern(arg)
-			files, err := doublestar.Glob(os.DirFS(realBasePath), pattern)
+		filter, err := newFileFilter()
+		if err != nil {
+			log.Fatal(err)
+		}
 
-			if err != nil {
-				log.Fatal(err)
-			}
+		fileList := collectFiles(args)
 
-			log.Tracef("Found %s: %d", arg, len(files))
+		defer cache.SaveAll()
 
-			for _, file := range files {
-				absPath, err := filepath.Abs(filepath.Join(realBasePath, file))
-				if err != nil {
-					panic(err)
-				}
-				fileList = append(fileList, absPath)
+		// Copies written next to the originals must not be picked up as inputs themselves
+		outputs := make(map[string]bool)
+		for _, file := range fileList {
+			outputName := outputFileName(file.Path, file.BasePath)
+			if !replacesOriginal(file.Path, outputName) {
+				outputs[outputName] = true
 			}
 		}
 
@@ -84,19 +86,55 @@ var rootCmd = &cobra.Command{
 			log.Error("Specified paths did not match any files")
 		}
 
+		sizeModel := loadSizeModel()
+
+		if sizeModel != nil && viper.GetString("learned-prediction") == "deprioritize" {
+			fileList = deprioritizeGrowing(fileList, sizeModel)
+		}
+
 		skip := make(chan bool, 1)
 		notifications.SetSkipChannel(skip)
 
-		for _, fileName := range fileList {
+		defer joblog.Finish()
+
+		for _, file := range fileList {
+			joblog.Finish()
+
 			if terminated {
 				return
 			}
 
-			if !shouldTranscode(fileName) {
+			fileName := file.Path
+
+			if outputs[fileName] {
+				log.Tracef("Skipping transcoder output: %s", fileName)
+				continue
+			}
+
+			if !hasTranscodedExtension(fileName) {
+				continue
+			}
+
+			if err := config.ApplyDirectories(fileName); err != nil {
+				log.Errorf("Skipping %s: %s", fileName, err)
+				continue
+			}
+
+			if reason := filter.excluded(file); reason != "" {
+				log.Debugf("Excluded %s: %s", fileName, reason)
+				continue
+			}
+
+			outputName := outputFileName(fileName, file.BasePath)
+			processedFileName := processedFileName(outputName)
+
+			if !shouldTranscode(fileName, processedFileName) {
 				// File already processed
 				continue
 			}
 
+			joblog.Start(fileName)
+
 			log.Infof("Transcoding: %s", fileName)
 			metadata, err := transcoder.ReadFileMetadata(fileName)
 
@@ -105,7 +143,30 @@ var rootCmd = &cobra.Command{
 				continue
 			}
 
-			tempFileName := fileName + ".transcode-temp"
+			if sizeModel != nil && viper.GetString("learned-prediction") == "skip" {
+				if record, ok := stats.FromMetadata(metadata); ok {
+					if ratio, grows := predictedToGrow(sizeModel, fileName, record); grows {
+						log.Infof("Skipped %s: predicted to grow to %.0f%% of the original", fileName, ratio*100)
+						recordKept(metadata, outputName, int64(float64(metadata.Format.SizeInt())*ratio))
+						mirrorOriginal(fileName, outputName)
+						continue
+					}
+				}
+			}
+
+			streams, err := transcoder.PlanStreams(metadata)
+
+			if err != nil {
+				log.Errorf("Incompatible streams in %s: %s", fileName, err)
+				continue
+			}
+
+			if err := os.MkdirAll(filepath.Dir(outputName), 0755); err != nil {
+				log.Errorf("Error creating output directory for %s: %s", outputName, err)
+				continue
+			}
+
+			tempFileName := transcodeTempName(outputName)
 
 			_, err = os.Stat(tempFileName)
 
@@ -119,17 +180,130 @@ var rootCmd = &cobra.Command{
 				continue
 			}
 
-			killed, lastReport, skipped := transcoder.TranscodeFile(fileName, tempFileName, metadata, skip)
+			job := &transcoder.Job{
+				FileName:     fileName,
+				TempFileName: tempFileName,
+				OutputName:   outputName,
+				Metadata:     metadata,
+				Streams:      streams,
+			}
+
+			err = transcoder.PrepareDeinterlace(job)
+
+			if err != nil {
+				log.Errorf("Error detecting scan type of %s: %s", fileName, err)
+				continue
+			}
+
+			err = transcoder.PrepareCrop(job)
+
+			if err != nil {
+				log.Errorf("Error detecting crop of %s: %s", fileName, err)
+				continue
+			}
+
+			err = transcoder.PrepareTonemap(job)
+
+			if err != nil {
+				log.Errorf("Error preparing tonemapping of %s: %s", fileName, err)
+				continue
+			}
+
+			err = transcoder.PrepareRateControl(job)
+
+			if errors.Is(err, transcoder.ErrTargetNotSmaller) {
+				log.Infof("Kept original %s: target size is not smaller than %s", fileName, utils.BytesHumanReadable(metadata.Format.SizeInt()))
+				recordKept(metadata, outputName, 0)
+				updateProcessedFile(fileName, processedFileName)
+				mirrorOriginal(fileName, outputName)
+				job.Cleanup()
+				continue
+			}
+
+			if err != nil {
+				log.Errorf("Error preparing rate control of %s: %s", fileName, err)
+				job.Cleanup()
+				continue
+			}
+
+			err = transcoder.PrepareCRF(job)
+
+			if err != nil {
+				log.Errorf("Error searching CRF of %s: %s", fileName, err)
+				job.Cleanup()
+				continue
+			}
+
+			err = transcoder.PrepareHDR(job)
+
+			if errors.Is(err, transcoder.ErrDolbyVisionSkipped) {
+				// No processed file is written, so the source is picked up again once --dolby-vision changes
+				log.Infof("Skipped Dolby Vision source, kept original: %s", fileName)
+				recordKept(metadata, outputName, 0)
+				mirrorOriginal(fileName, outputName)
+				job.Cleanup()
+				continue
+			}
+
+			if err != nil {
+				log.Errorf("Error preparing HDR metadata of %s: %s", fileName, err)
+				job.Cleanup()
+				continue
+			}
+
+			err = transcoder.PrepareFirstPass(job)
+
+			if err != nil {
+				log.Errorf("Error running first pass of %s: %s", fileName, err)
+				job.Cleanup()
+				continue
+			}
+
+			err = transcoder.PrepareChunks(job)
+
+			if err != nil {
+				log.Errorf("Error splitting %s into chunks: %s", fileName, err)
+				job.Cleanup()
+				continue
+			}
+
+			err = transcoder.PrepareEarlyExit(job)
+
+			if errors.Is(err, transcoder.ErrPredictedLarger) {
+				log.Infof("Kept original %s: predicted to be larger than %s", fileName, utils.BytesHumanReadable(job.SizeLimit()))
+				stats.RecordEstimate(fileName, metadata, transcoder.PredictedSize(job))
+				recordKept(metadata, outputName, transcoder.PredictedSize(job))
+				updateProcessedFile(fileName, processedFileName)
+				mirrorOriginal(fileName, outputName)
+				job.Cleanup()
+				continue
+			}
+
+			if err != nil {
+				log.Errorf("Error predicting size of %s: %s", fileName, err)
+				job.Cleanup()
+				continue
+			}
+
+			err = transcoder.ExtractStreams(fileName, outputName, streams)
+
+			if err != nil {
+				log.Errorf("Error extracting streams from %s: %s", fileName, err)
+				job.Cleanup()
+				continue
+			}
+
+			killed, lastReport, skipped := transcoder.TranscodeFile(job, skip)
+
+			job.Cleanup()
+
+			cache.SaveIfDue(cacheSaveInterval)
 
 			if terminated {
 				notifications.NotifyEnd(nil, nil, models.ResultError)
 				continue
 			}
 
-			lastDot := strings.LastIndex(fileName, ".")
-			extCorrectedOriginal := fileName[:lastDot] + outputFileExtension
-			processedFileName := filepath.Dir(extCorrectedOriginal) + "/." + filepath.Base(extCorrectedOriginal) + ".processed"
-
 			updateProcessedFile(tempFileName, processedFileName)
 
 			if killed && !skipped {
@@ -146,24 +320,27 @@ var rootCmd = &cobra.Command{
 				}
 
 				if lastReport != nil {
-					if int64(lastReport.TotalSize) > metadata.Format.SizeInt() {
+					if int64(lastReport.TotalSize) > job.SizeLimit() {
 						log.Infof("Kept original %s: %s < %s",
 							fileName,
-							utils.BytesHumanReadable(metadata.Format.SizeInt()),
+							utils.BytesHumanReadable(job.SizeLimit()),
 							utils.BytesHumanReadable(int64(lastReport.TotalSize)),
 						)
 
-						notifications.NotifyEnd(nil, lastReport, models.ResultKeepOriginal)
-					}
+						recordEstimate(fileName, metadata, lastReport)
 
-					skipConfidence := utils.SkipConfidenceMeta(metadata, lastReport.Frame, lastReport.TotalSize)
-					if skipConfidence > viper.GetFloat64("skip-confidence") {
+						notifications.NotifyEnd(nil, lastReport, models.ResultKeepOriginal)
+						mirrorOriginal(fileName, outputName)
+					} else if skipConfidence := transcoder.SkipConfidence(job, job.SizeLimit(), lastReport); skipConfidence > viper.GetFloat64("skip-confidence") {
 						log.Infof("Kept original %s: Skip confidence of %.2f",
 							fileName,
 							skipConfidence,
 						)
 
-						notifications.NotifyEnd(nil, lastReport, models.ResultKeepOriginal)
+						recordEstimate(fileName, metadata, lastReport)
+
+						notifications.NotifySkipConfidenceEnd(nil, lastReport)
+						mirrorOriginal(fileName, outputName)
 					}
 				}
 
@@ -173,7 +350,7 @@ var rootCmd = &cobra.Command{
 			if skipped {
 				updateProcessedFile(fileName, processedFileName)
 
-				// Transcoded file was skipped
+				// Transcoded file was skipped, a manual stop says nothing about the size it would have reached
 				err := os.Remove(tempFileName)
 
 				if err != nil && !os.IsNotExist(err) {
@@ -183,18 +360,21 @@ var rootCmd = &cobra.Command{
 
 				log.Infof("Skipped, kept original: %s", fileName)
 				notifications.NotifyEnd(nil, lastReport, models.ResultSkipped)
+				mirrorOriginal(fileName, outputName)
 
 				continue
 			}
 
-			resultMetadata, err := transcoder.ReadFileMetadata(tempFileName)
+			resultMetadata, err := transcoder.ProbeFile(tempFileName)
 			if err != nil {
 				log.Infof("failed reading metadata: %s", err)
 				notifications.NotifyEnd(nil, lastReport, models.ResultError)
 				continue
 			}
 
-			if viper.GetBool("keep-old") && resultMetadata.Format.SizeInt() > metadata.Format.SizeInt() {
+			stats.RecordJob(fileName, metadata, resultMetadata)
+
+			if viper.GetBool("keep-old") && resultMetadata.Format.SizeInt() > job.SizeLimit() {
 				// Transcoded file is bigger than original
 				err := os.Remove(tempFileName)
 
@@ -207,12 +387,13 @@ var rootCmd = &cobra.Command{
 
 				log.Infof("Kept original %s: %s < %s",
 					fileName,
-					utils.BytesHumanReadable(metadata.Format.SizeInt()),
+					utils.BytesHumanReadable(job.SizeLimit()),
 					utils.BytesHumanReadable(resultMetadata.Format.SizeInt()),
 				)
 
 				notifications.NotifyEnd(resultMetadata, nil, models.ResultKeepOriginal)
-			} else if viper.GetBool("keep-old") && utils.SkipConfidenceMeta(metadata, resultMetadata.Frames(), int(resultMetadata.Format.SizeInt())) > viper.GetFloat64("skip-confidence") {
+				mirrorOriginal(fileName, outputName)
+			} else if viper.GetBool("keep-old") && utils.SkipConfidenceAt(job.SizeLimit(), int(resultMetadata.Format.SizeInt()), metadata.ResultCompletion(resultMetadata)) > viper.GetFloat64("skip-confidence") {
 				// Transcoded file is skipped due to extrapolated data
 				err := os.Remove(tempFileName)
 
@@ -225,11 +406,12 @@ var rootCmd = &cobra.Command{
 
 				log.Infof("Kept original %s: Skip confidence of %.2f",
 					fileName,
-					utils.SkipConfidenceMeta(metadata, resultMetadata.Frames(), int(resultMetadata.Format.SizeInt())),
+					utils.SkipConfidenceAt(job.SizeLimit(), int(resultMetadata.Format.SizeInt()), metadata.ResultCompletion(resultMetadata)),
 				)
 
-				notifications.NotifyEnd(resultMetadata, nil, models.ResultKeepOriginal)
-			} else {
+				notifications.NotifySkipConfidenceEnd(resultMetadata, nil)
+				mirrorOriginal(fileName, outputName)
+			} else if replacesOriginal(fileName, outputName) {
 				// Transcoded file is smaller than original
 				err := os.Remove(fileName)
 
@@ -238,28 +420,83 @@ var rootCmd = &cobra.Command{
 					continue
 				}
 
-				err = os.Rename(tempFileName, extCorrectedOriginal)
+				err = os.Rename(tempFileName, outputName)
 
 				if err != nil {
-					log.Errorf("Error renaming file %s to %s: %s", tempFileName, extCorrectedOriginal, err)
+					log.Errorf("Error renaming file %s to %s: %s", tempFileName, outputName, err)
 					continue
 				}
 
 				log.Infof("Replaced %s with transcoded: %s < %s",
 					fileName,
 					utils.BytesHumanReadable(resultMetadata.Format.SizeInt()),
-					utils.BytesHumanReadable(metadata.Format.SizeInt()),
+					utils.BytesHumanReadable(job.SizeLimit()),
 				)
 
 				notifications.NotifyEnd(resultMetadata, nil, models.ResultReplaced)
+			} else {
+				// Transcoded copy is written to the output, original stays untouched
+				err := os.Rename(tempFileName, outputName)
+
+				if err != nil {
+					log.Errorf("Error renaming file %s to %s: %s", tempFileName, outputName, err)
+					continue
+				}
+
+				updateProcessedFile(fileName, processedFileName)
+
+				log.Infof("Wrote transcoded copy of %s to %s: %s < %s",
+					fileName,
+					outputName,
+					utils.BytesHumanReadable(resultMetadata.Format.SizeInt()),
+					utils.BytesHumanReadable(job.SizeLimit()),
+				)
+
+				notifications.NotifyEnd(resultMetadata, nil, models.ResultCopied)
 			}
 
 		}
 	},
 }
 
+func setupLogging(cmd *cobra.Command) {
+	level, err := log.ParseLevel(LogLevel)
+
+	if err != nil {
+		panic(err)
+	}
+
+	switch LogFormat {
+	case "text":
+		log.SetFormatter(config.RedactingFormatter{
+			Formatter: &log.TextFormatter{
+				ForceColors: ForceColors,
+			},
+		})
+		joblog.Formatter = config.RedactingFormatter{
+			Formatter: &log.TextFormatter{
+				DisableColors: true,
+				FullTimestamp: true,
+			},
+		}
+	case "json":
+		log.SetFormatter(config.RedactingFormatter{Formatter: &log.JSONFormatter{}})
+		joblog.Formatter = config.RedactingFormatter{Formatter: &log.JSONFormatter{}}
+	default:
+		panic("unknown log format " + LogFormat)
+	}
+
+	log.AddHook(joblog.Hook{})
+	log.SetOutput(os.Stdout)
+	if cmd.HasParent() {
+		// Subcommands print their results to stdout
+		log.SetOutput(os.Stderr)
+	}
+	log.SetLevel(level)
+}
+
 func Execute() {
-	terminate := make(chan os.Signal)
+	terminate := make(chan os.Signal, 1)
 
 	go func() {
 		<-terminate
@@ -280,146 +517,210 @@ func init() {
 
 	rootCmd.PersistentFlags().StringVar(&LogLevel, "log", "info", "The log level to output")
 	rootCmd.PersistentFlags().BoolVar(&ForceColors, "colors", false, "Force output with colors")
-
-	rootCmd.PersistentFlags().StringP("flags", "f", "-map 0 -c:v libx265 -preset ultrafast -x265-params crf=16 -c:a aac -strict -2 -b:a 256k", "The base flags used for all transcodes")
+	rootCmd.PersistentFlags().StringVar(&LogFormat, "log-format", "text", "The log output format (text, json)")
+
+	rootCmd.PersistentFlags().String("config", "", "Config file to read instead of config.* in the working directory")
+
+	rootCmd.PersistentFlags().StringP("flags", "f", "-c:v libx265 -preset ultrafast -x265-params crf=16 -c:a aac -strict -2 -b:a 256k", "The base flags used for all transcodes")
+	rootCmd.PersistentFlags().String("profile", "", "Name of the profile from the config file to apply")
+	rootCmd.PersistentFlags().String("container", "mkv", "Output container (mkv, mp4, webm)")
+	rootCmd.PersistentFlags().StringP("output", "o", "{dir}/{stem}.{ext}", "Output file template ({dir}, {reldir}, {stem}, {name}, {ext}), originals are replaced when only the extension differs")
+	rootCmd.PersistentFlags().String("mirror", "", "Transcode into the same relative path under this directory, originals are never modified")
+	rootCmd.PersistentFlags().String("mirror-fallback", "link", "What to place in the mirror when the original is kept (link, copy, skip)")
+	rootCmd.PersistentFlags().Bool("preserve-metadata", true, "Explicitly carry over container tags, chapters, stream titles, languages and dispositions")
+	rootCmd.PersistentFlags().StringSlice("metadata-tags", []string{}, "Container tags to write in the form KEY=VALUE, an empty value removes the tag")
+	rootCmd.PersistentFlags().String("stamp-tag", "TRANSCODER_GO", "Container tag stamped with the profile and flags used, empty to disable")
+	rootCmd.PersistentFlags().Bool("hdr-passthrough", true, "Carry HDR10, HDR10+ and Dolby Vision metadata over to x265")
+	rootCmd.PersistentFlags().String("dolby-vision", "strip", "How to handle Dolby Vision sources (skip, strip, passthrough)")
+	rootCmd.PersistentFlags().String("deinterlace", "off", "Deinterlace or inverse telecine the source (off, auto, always)")
+	rootCmd.PersistentFlags().Int("deinterlace-frames", 1000, "Number of frames analyzed to detect interlacing")
+	rootCmd.PersistentFlags().Int("chunks", 0, "Encode long files in chunks with this many parallel ffmpeg processes, 0 to disable")
+	rootCmd.PersistentFlags().Float64("chunk-length", 120, "Minimum length of a chunk in seconds")
+	rootCmd.PersistentFlags().String("chunk-split", "keyframes", "Where chunks are split (keyframes, scene)")
+	rootCmd.PersistentFlags().Float64("scene-threshold", 0.4, "Scene change score used to split chunks at scene cuts")
+	rootCmd.PersistentFlags().Bool("crop", false, "Detect black bars before encoding and crop them")
+	rootCmd.PersistentFlags().Int("crop-samples", 6, "Number of timestamps sampled for crop detection")
+	rootCmd.PersistentFlags().Int("crop-margin", 4, "Pixels kept on every side of the detected crop")
+	rootCmd.PersistentFlags().Bool("tonemap", false, "Tonemap HDR sources to SDR bt709")
+	rootCmd.PersistentFlags().String("tonemap-algorithm", "hable", "Tonemapping algorithm (none, clip, linear, gamma, reinhard, hable, mobius)")
+	rootCmd.PersistentFlags().Float64("tonemap-peak", 100, "Nominal peak luminance in cd/m2 used when linearizing HDR sources")
+	rootCmd.PersistentFlags().String("tonemap-pix-fmt", "yuv420p", "Pixel format of tonemapped output")
+	rootCmd.PersistentFlags().String("target-bitrate", "", "Encode in two passes to this total bitrate, e.g. 4M")
+	rootCmd.PersistentFlags().String("target-size-per-hour", "", "Encode in two passes to at most this size per hour, e.g. 2G")
+	rootCmd.PersistentFlags().Float64("target-tolerance", 0.1, "Fraction a size target may be exceeded by before exiting early")
+	rootCmd.PersistentFlags().String("max-bitrate", "", "Cap the bitrate of CRF encodes, e.g. 8M")
+	rootCmd.PersistentFlags().Float64("target-vmaf", 0, "Search for the highest CRF reaching this VMAF score using sample encodes, 0 to disable")
+	rootCmd.PersistentFlags().IntSlice("crf-candidates", []int{16, 18, 20, 22, 24, 26, 28}, "CRF values tried by the target quality search")
+	rootCmd.PersistentFlags().Int("crf-samples", 3, "Number of samples encoded per CRF candidate")
+	rootCmd.PersistentFlags().Float64("crf-sample-length", 5, "Length of each CRF sample in seconds")
+	rootCmd.PersistentFlags().String("result-log", "", "File every job result is appended to (defaults to results.jsonl in the cache directory)")
+	rootCmd.PersistentFlags().Bool("no-result-log", false, "Do not write the result log")
+	rootCmd.PersistentFlags().Bool("no-cache", false, "Always probe files instead of using the cached ffprobe results")
+	rootCmd.PersistentFlags().Bool("cache-inode", false, "Also invalidate cached ffprobe results when the inode of a file changes")
+	rootCmd.PersistentFlags().String("cache-dir", "", "Directory for persistent caches (defaults to the user cache directory)")
+	rootCmd.PersistentFlags().StringSlice("streams", []string{}, "Stream rules in the form type[:language[:codec]]=keep|drop|srt|ass|extract, first match wins")
 	rootCmd.PersistentFlags().StringSliceP("extensions", "e", []string{".mp4", ".mkv", ".flv"}, "Transcoded file extensions")
+	rootCmd.PersistentFlags().StringSlice("exclude", []string{}, "Glob patterns of files and directories to leave alone, matched against the path relative to the searched directory")
+	rootCmd.PersistentFlags().String("min-size", "", "Only transcode files of at least this size, e.g. 100M")
+	rootCmd.PersistentFlags().String("max-size", "", "Only transcode files of at most this size, e.g. 50G")
+	rootCmd.PersistentFlags().Duration("min-duration", 0, "Only transcode files playing at least this long, e.g. 5m")
+	rootCmd.PersistentFlags().Duration("max-duration", 0, "Only transcode files playing at most this long, e.g. 4h")
+	rootCmd.PersistentFlags().Duration("min-age", 0, "Only transcode files not modified for this long, e.g. 1h for in-flight downloads")
+	rootCmd.PersistentFlags().String("job-logs", "failed", "Which per-job logs with the ffmpeg output to keep (off, failed, all)")
+	rootCmd.PersistentFlags().String("job-log-dir", "", "Directory for per-job logs (defaults to the cache directory)")
+	rootCmd.PersistentFlags().String("ffmpeg-log-level", "info", "ffmpeg log level captured in per-job logs")
 	rootCmd.PersistentFlags().Int("interval", 5, "How often to output transcoding status")
 	rootCmd.PersistentFlags().Bool("stderr", false, "Whether to output ffmpeg stderr stream")
 	rootCmd.PersistentFlags().Bool("keep-old", true, "Keep old version of video if transcoded version is larger")
 	rootCmd.PersistentFlags().Bool("early-exit", true, "Early exit if transcoded version is larger than original (requires keep-old)")
 	rootCmd.PersistentFlags().Bool("nice", true, "Whether to lower the priority of ffmpeg process")
 	rootCmd.PersistentFlags().Float64("skip-confidence", 15, "Skip confidence for early exit")
+	rootCmd.PersistentFlags().String("learned-prediction", "off", "What to do with files predicted to grow by the model learned from earlier jobs (off, skip, deprioritize)")
+	rootCmd.PersistentFlags().Int("predict-min-samples", 10, "Number of finished jobs of a profile required before sizes are predicted")
+	rootCmd.PersistentFlags().Float64("predict-max-ratio", 1, "Output/input size ratio above which a file is predicted to grow")
+	rootCmd.PersistentFlags().String("early-exit-predictor", "extrapolate", "How the final size is predicted for early exit (extrapolate, sample)")
+	rootCmd.PersistentFlags().Int("predictor-samples", 4, "Number of samples encoded up front by the sample predictor")
+	rootCmd.PersistentFlags().Float64("predictor-sample-length", 10, "Length of every sample of the sample predictor in seconds")
+	rootCmd.PersistentFlags().Float64("predictor-sample-weight", 0.75, "How much the sample predictor trusts its samples for the part not encoded yet (0-1)")
 
 	rootCmd.PersistentFlags().String("tg-bot-key", "", "Telegram Bot API Key")
 	rootCmd.PersistentFlags().String("tg-chat-id", "", "Telegram Bot Chat ID")
 	rootCmd.PersistentFlags().Int("tg-admin-id", 0, "Telegram Admin User ID")
 
+	_ = viper.BindPFlag("config", rootCmd.PersistentFlags().Lookup("config"))
 	_ = viper.BindPFlag("flags", rootCmd.PersistentFlags().Lookup("flags"))
+	_ = viper.BindPFlag("profile", rootCmd.PersistentFlags().Lookup("profile"))
+	_ = viper.BindPFlag("container", rootCmd.PersistentFlags().Lookup("container"))
+	_ = viper.BindPFlag("output", rootCmd.PersistentFlags().Lookup("output"))
+	_ = viper.BindPFlag("mirror", rootCmd.PersistentFlags().Lookup("mirror"))
+	_ = viper.BindPFlag("mirror-fallback", rootCmd.PersistentFlags().Lookup("mirror-fallback"))
+	_ = viper.BindPFlag("preserve-metadata", rootCmd.PersistentFlags().Lookup("preserve-metadata"))
+	_ = viper.BindPFlag("metadata-tags", rootCmd.PersistentFlags().Lookup("metadata-tags"))
+	_ = viper.BindPFlag("stamp-tag", rootCmd.PersistentFlags().Lookup("stamp-tag"))
+	_ = viper.BindPFlag("hdr-passthrough", rootCmd.PersistentFlags().Lookup("hdr-passthrough"))
+	_ = viper.BindPFlag("dolby-vision", rootCmd.PersistentFlags().Lookup("dolby-vision"))
+	_ = viper.BindPFlag("deinterlace", rootCmd.PersistentFlags().Lookup("deinterlace"))
+	_ = viper.BindPFlag("deinterlace-frames", rootCmd.PersistentFlags().Lookup("deinterlace-frames"))
+	_ = viper.BindPFlag("chunks", rootCmd.PersistentFlags().Lookup("chunks"))
+	_ = viper.BindPFlag("chunk-length", rootCmd.PersistentFlags().Lookup("chunk-length"))
+	_ = viper.BindPFlag("chunk-split", rootCmd.PersistentFlags().Lookup("chunk-split"))
+	_ = viper.BindPFlag("scene-threshold", rootCmd.PersistentFlags().Lookup("scene-threshold"))
+	_ = viper.BindPFlag("crop", rootCmd.PersistentFlags().Lookup("crop"))
+	_ = viper.BindPFlag("crop-samples", rootCmd.PersistentFlags().Lookup("crop-samples"))
+	_ = viper.BindPFlag("crop-margin", rootCmd.PersistentFlags().Lookup("crop-margin"))
+	_ = viper.BindPFlag("tonemap", rootCmd.PersistentFlags().Lookup("tonemap"))
+	_ = viper.BindPFlag("tonemap-algorithm", rootCmd.PersistentFlags().Lookup("tonemap-algorithm"))
+	_ = viper.BindPFlag("tonemap-peak", rootCmd.PersistentFlags().Lookup("tonemap-peak"))
+	_ = viper.BindPFlag("tonemap-pix-fmt", rootCmd.PersistentFlags().Lookup("tonemap-pix-fmt"))
+	_ = viper.BindPFlag("target-bitrate", rootCmd.PersistentFlags().Lookup("target-bitrate"))
+	_ = viper.BindPFlag("target-size-per-hour", rootCmd.PersistentFlags().Lookup("target-size-per-hour"))
+	_ = viper.BindPFlag("target-tolerance", rootCmd.PersistentFlags().Lookup("target-tolerance"))
+	_ = viper.BindPFlag("max-bitrate", rootCmd.PersistentFlags().Lookup("max-bitrate"))
+	_ = viper.BindPFlag("target-vmaf", rootCmd.PersistentFlags().Lookup("target-vmaf"))
+	_ = viper.BindPFlag("crf-candidates", rootCmd.PersistentFlags().Lookup("crf-candidates"))
+	_ = viper.BindPFlag("crf-samples", rootCmd.PersistentFlags().Lookup("crf-samples"))
+	_ = viper.BindPFlag("crf-sample-length", rootCmd.PersistentFlags().Lookup("crf-sample-length"))
+	_ = viper.BindPFlag("result-log", rootCmd.PersistentFlags().Lookup("result-log"))
+	_ = viper.BindPFlag("no-result-log", rootCmd.PersistentFlags().Lookup("no-result-log"))
+	_ = viper.BindPFlag("no-cache", rootCmd.PersistentFlags().Lookup("no-cache"))
+	_ = viper.BindPFlag("cache-inode", rootCmd.PersistentFlags().Lookup("cache-inode"))
+	_ = viper.BindPFlag("cache-dir", rootCmd.PersistentFlags().Lookup("cache-dir"))
+	_ = viper.BindPFlag("streams", rootCmd.PersistentFlags().Lookup("streams"))
 	_ = viper.BindPFlag("extensions", rootCmd.PersistentFlags().Lookup("extensions"))
+	_ = viper.BindPFlag("exclude", rootCmd.PersistentFlags().Lookup("exclude"))
+	_ = viper.BindPFlag("min-size", rootCmd.PersistentFlags().Lookup("min-size"))
+	_ = viper.BindPFlag("max-size", rootCmd.PersistentFlags().Lookup("max-size"))
+	_ = viper.BindPFlag("min-duration", rootCmd.PersistentFlags().Lookup("min-duration"))
+	_ = viper.BindPFlag("max-duration", rootCmd.PersistentFlags().Lookup("max-duration"))
+	_ = viper.BindPFlag("min-age", rootCmd.PersistentFlags().Lookup("min-age"))
+	_ = viper.BindPFlag("job-logs", rootCmd.PersistentFlags().Lookup("job-logs"))
+	_ = viper.BindPFlag("job-log-dir", rootCmd.PersistentFlags().Lookup("job-log-dir"))
+	_ = viper.BindPFlag("ffmpeg-log-level", rootCmd.PersistentFlags().Lookup("ffmpeg-log-level"))
 	_ = viper.BindPFlag("interval", rootCmd.PersistentFlags().Lookup("interval"))
 	_ = viper.BindPFlag("stderr", rootCmd.PersistentFlags().Lookup("stderr"))
 	_ = viper.BindPFlag("keep-old", rootCmd.PersistentFlags().Lookup("keep-old"))
 	_ = viper.BindPFlag("early-exit", rootCmd.PersistentFlags().Lookup("early-exit"))
 	_ = viper.BindPFlag("nice", rootCmd.PersistentFlags().Lookup("nice"))
 	_ = viper.BindPFlag("skip-confidence", rootCmd.PersistentFlags().Lookup("skip-confidence"))
+	_ = viper.BindPFlag("learned-prediction", rootCmd.PersistentFlags().Lookup("learned-prediction"))
+	_ = viper.BindPFlag("predict-min-samples", rootCmd.PersistentFlags().Lookup("predict-min-samples"))
+	_ = viper.BindPFlag("predict-max-ratio", rootCmd.PersistentFlags().Lookup("predict-max-ratio"))
+	_ = viper.BindPFlag("early-exit-predictor", rootCmd.PersistentFlags().Lookup("early-exit-predictor"))
+	_ = viper.BindPFlag("predictor-samples", rootCmd.PersistentFlags().Lookup("predictor-samples"))
+	_ = viper.BindPFlag("predictor-sample-length", rootCmd.PersistentFlags().Lookup("predictor-sample-length"))
+	_ = viper.BindPFlag("predictor-sample-weight", rootCmd.PersistentFlags().Lookup("predictor-sample-weight"))
 
 	_ = viper.BindPFlag("tg-bot-key", rootCmd.PersistentFlags().Lookup("tg-bot-key"))
 	_ = viper.BindPFlag("tg-chat-id", rootCmd.PersistentFlags().Lookup("tg-chat-id"))
 	_ = viper.BindPFlag("tg-admin-id", rootCmd.PersistentFlags().Lookup("tg-admin-id"))
-}
-
-func shouldTranscode(fileName string) bool {
-	if terminated {
-		return false
-	}
-
-	ext := filepath.Ext(fileName)
-
-	valid := false
-	for _, extension := range viper.GetStringSlice("extensions") {
-		if ext == extension {
-			valid = true
-			break
-		}
-	}
-
-	if !valid {
-		return false
-	}
-
-	lastDot := strings.LastIndex(fileName, ".")
-	extCorrectedOriginal := fileName[:lastDot] + outputFileExtension
-	processedFileName := filepath.Dir(extCorrectedOriginal) + "/." + filepath.Base(extCorrectedOriginal) + ".processed"
-
-	stat, err := os.Stat(processedFileName)
 
-	if err != nil && !os.IsNotExist(err) {
-		log.Errorf("Error reading file %s: %s", processedFileName, err)
-		return false
+	for _, key := range config.SecretKeys {
+		rootCmd.PersistentFlags().String(key+"-file", "", "File to read the "+key+" option from")
+		_ = viper.BindPFlag(key+"-file", rootCmd.PersistentFlags().Lookup(key+"-file"))
 	}
+}
 
-	if stat == nil {
-		// File not transcoded ever
-		return true
-	}
-
-	if stat.Size() == 0 {
-		// File processed using old transcoder, update meta file and skip
-		log.Warningf("Updating processed file with file size from old transcoder: %s", fileName)
-		updateProcessedFile(fileName, processedFileName)
-		return false
-	}
-
-	processedData, err := ioutil.ReadFile(processedFileName)
-
-	if err != nil {
-		log.Errorf("Error reading file %s: %s", processedFileName, err)
-		return false
-	}
-
-	if len(processedData) == 0 {
-		// File processed using old transcoder, update meta file and skip
-		log.Warningf("Updating processed file with file size from old transcoder: %s", fileName)
-		updateProcessedFile(fileName, processedFileName)
-		return false
-	}
-
-	parsed, err := strconv.ParseInt(string(processedData), 10, 64)
-
-	if err != nil {
-		log.Errorf("Error parsing %s: %s", string(processedData), err)
-		return false
-	}
+// collectFiles expands the path arguments, which may contain globs, into the matching files
+func collectFiles(args []string) []inputFile {
+	fileList := make([]inputFile, 0)
 
-	originalStat, err := os.Stat(fileName)
+	for _, arg := range args {
+		realBasePath, pattern := doublestar.SplitPattern(arg)
+		files, err := doublestar.Glob(os.DirFS(realBasePath), pattern)
 
-	if err != nil {
-		log.Errorf("Error reading file %s: %s", fileName, err)
-		return false
-	}
+		if err != nil {
+			log.Fatal(err)
+		}
 
-	if parsed == originalStat.Size() {
-		return false
-	}
+		absBasePath, err := filepath.Abs(realBasePath)
+		if err != nil {
+			panic(err)
+		}
 
-	if !deleteProcessedFile(processedFileName) {
-		return false
-	}
+		absMirror := ""
+		if mirror := viper.GetString("mirror"); mirror != "" {
+			absMirror, err = filepath.Abs(mirror)
+			if err != nil {
+				panic(err)
+			}
 
-	return true
-}
+			if isWithin(absMirror, absBasePath) {
+				log.Fatalf("Mirror directory must not contain the source directory: %s", absMirror)
+			}
+		}
 
-func updateProcessedFile(fileName string, processedFileName string) {
-	if !deleteProcessedFile(processedFileName) {
-		return
-	}
+		log.Tracef("Found %s: %d", arg, len(files))
 
-	originalStat, err := os.Stat(fileName)
+		for _, file := range files {
+			absPath, err := filepath.Abs(filepath.Join(realBasePath, file))
+			if err != nil {
+				panic(err)
+			}
 
-	if os.IsNotExist(err) {
-		return
-	}
+			if absMirror != "" && isWithin(absMirror, absPath) {
+				// Outputs of an earlier run with the mirror inside the source directory
+				log.Tracef("Skipping %s: inside mirror directory", absPath)
+				continue
+			}
 
-	if err != nil {
-		log.Errorf("Error reading file %s: %s", fileName, err)
-		return
+			fileList = append(fileList, inputFile{
+				Path:     absPath,
+				BasePath: absBasePath,
+			})
+		}
 	}
 
-	err = ioutil.WriteFile(processedFileName, []byte(strconv.FormatInt(originalStat.Size(), 10)), 0644)
-
-	if err != nil {
-		log.Errorf("Error writing file %s: %s", processedFileName, err)
-		return
-	}
+	return fileList
 }
 
-func deleteProcessedFile(processedFileName string) bool {
-	err := os.Remove(processedFileName)
+func hasTranscodedExtension(fileName string) bool {
+	ext := filepath.Ext(fileName)
 
-	if err != nil && !os.IsNotExist(err) {
-		log.Errorf("Error deleting file %s: %s", processedFileName, err)
-		return false
+	for _, extension := range viper.GetStringSlice("extensions") {
+		if ext == extension {
+			return true
+		}
 	}
 
-	return true
+	return false
 }
@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"github.com/Vilsol/transcoder-go/inventory"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"runtime"
+)
+
+var scanFormat string
+var scanExport string
+var scanWorkers int
+
+var scanCmd = &cobra.Command{
+	Use:   "scan <path> ...",
+	Short: "Probe matching files and export an inventory of the library",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if scanFormat == "sqlite" && scanExport == "" {
+			log.Fatalf("The sqlite format requires --export")
+		}
+
+		files := make([]string, 0)
+		for _, file := range collectFiles(args) {
+			if hasTranscodedExtension(file.Path) {
+				files = append(files, file.Path)
+			}
+		}
+
+		log.Infof("Scanning %d files with %d workers", len(files), scanWorkers)
+
+		items := inventory.Scan(files, scanWorkers)
+
+		if scanFormat == "sqlite" {
+			if err := inventory.WriteSQLite(scanExport, items); err != nil {
+				log.Fatal(err)
+			}
+
+			log.Infof("Wrote inventory of %d files to %s", len(items), scanExport)
+			return
+		}
+
+		var out io.Writer = os.Stdout
+		if scanExport != "" {
+			file, err := os.Create(scanExport)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			defer file.Close()
+			out = file
+		}
+
+		if err := inventory.Write(out, items, scanFormat); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	scanCmd.Flags().StringVar(&scanFormat, "format", "csv", "Inventory format (csv, json, sqlite)")
+	scanCmd.Flags().StringVar(&scanExport, "export", "", "File the inventory is written to, defaults to stdout")
+	scanCmd.Flags().IntVar(&scanWorkers, "workers", runtime.NumCPU(), "Number of parallel ffprobe processes")
+
+	rootCmd.AddCommand(scanCmd)
+}
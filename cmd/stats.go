@@ -0,0 +1,157 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/Vilsol/transcoder-go/cache"
+	"github.com/Vilsol/transcoder-go/models"
+	"github.com/Vilsol/transcoder-go/stats"
+	"github.com/Vilsol/transcoder-go/transcoder"
+	"github.com/Vilsol/transcoder-go/utils"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"os"
+	"sort"
+)
+
+var statsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Statistics learned from finished jobs",
+}
+
+var statsPredictCmd = &cobra.Command{
+	Use:   "predict <file> ...",
+	Short: "Predict the output size of files from the outcomes of earlier jobs",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		model, err := stats.LoadModel()
+		if err != nil {
+			log.Fatalf("Cannot predict sizes: %s", err)
+		}
+
+		fmt.Printf("Profile %s, model fitted to %d jobs\n", stats.Profile(), model.Samples)
+
+		defer cache.SaveAll()
+
+		for _, fileName := range args {
+			metadata, err := transcoder.ReadFileMetadata(fileName)
+			if err != nil {
+				log.Errorf("failed reading metadata: %s", err)
+				continue
+			}
+
+			record, ok := stats.FromMetadata(metadata)
+			if !ok {
+				log.Errorf("Missing video characteristics: %s", fileName)
+				continue
+			}
+
+			ratio := model.Predict(record)
+
+			fmt.Printf("\n%s\n", fileName)
+			fmt.Printf("  Source:          %s in %s, %dx%d @ %.3f fps, %.4f bits/pixel\n",
+				record.Codec, record.Container, record.Width, record.Height, record.FrameRate, record.BitsPerPixel)
+			fmt.Printf("  Predicted ratio: %.2f\n", ratio)
+			fmt.Printf("  Predicted size:  %s --> %s\n",
+				utils.BytesHumanReadable(record.InputSize), utils.BytesHumanReadable(int64(float64(record.InputSize)*ratio)))
+
+			if ratio > viper.GetFloat64("predict-max-ratio") {
+				fmt.Println("  Expected to grow, would not be transcoded")
+			}
+		}
+	},
+}
+
+func init() {
+	statsCmd.AddCommand(statsPredictCmd)
+	rootCmd.AddCommand(statsCmd)
+}
+
+// recordEstimate remembers the output size extrapolated from the last progress report of a job that was stopped early
+func recordEstimate(fileName string, metadata *models.FileMetadata, report *models.ProgressReport) {
+	if report == nil {
+		return
+	}
+
+	completion := metadata.Completion(report)
+	if completion < 1 {
+		// Too little was encoded to extrapolate from
+		return
+	}
+
+	if completion > 100 {
+		completion = 100
+	}
+
+	stats.RecordEstimate(fileName, metadata, int64(float64(report.TotalSize)/completion*100))
+}
+
+// loadSizeModel returns the learned size model if learned prediction is enabled and enough jobs were recorded
+func loadSizeModel() *stats.Model {
+	if viper.GetString("learned-prediction") == "off" {
+		return nil
+	}
+
+	model, err := stats.LoadModel()
+	if err != nil {
+		log.Infof("Learned size prediction unavailable: %s", err)
+		return nil
+	}
+
+	return model
+}
+
+// predictedToGrow reports whether the model expects the output to exceed the configured ratio
+func predictedToGrow(model *stats.Model, fileName string, record stats.Record) (float64, bool) {
+	ratio := model.Predict(record)
+	if ratio > viper.GetFloat64("predict-max-ratio") {
+		log.Debugf("Predicted size ratio of %s: %.2f", fileName, ratio)
+		return ratio, true
+	}
+
+	return ratio, false
+}
+
+// deprioritizeGrowing moves files that are predicted to grow to the end of the list, least growth first
+func deprioritizeGrowing(fileList []inputFile, model *stats.Model) []inputFile {
+	ratios := make(map[string]float64)
+	growing := 0
+
+	for _, file := range fileList {
+		if !hasTranscodedExtension(file.Path) {
+			continue
+		}
+
+		if _, err := os.Stat(processedFileName(outputFileName(file.Path, file.BasePath))); err == nil {
+			continue
+		}
+
+		metadata, err := transcoder.ReadFileMetadata(file.Path)
+		if err != nil || transcoder.IsStamped(metadata) {
+			continue
+		}
+
+		record, ok := stats.FromMetadata(metadata)
+		if !ok {
+			continue
+		}
+
+		if ratio, grows := predictedToGrow(model, file.Path, record); grows {
+			ratios[file.Path] = ratio
+			growing++
+		}
+	}
+
+	sorted := make([]inputFile, len(fileList))
+	copy(sorted, fileList)
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return ratios[sorted[i].Path] < ratios[sorted[j].Path]
+	})
+
+	if growing > 0 {
+		log.Infof("Deprioritized %d files predicted to grow", growing)
+	}
+
+	return sorted
+}
@@ -1,16 +1,59 @@
 package config
 
 import (
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 )
 
-func InitializeConfig() {
+// InitializeConfig reads the config file and secrets, the --config flag selects a file other than ./config.*
+func InitializeConfig() error {
 	viper.SetConfigName("config")
 	viper.AddConfigPath(".")
+
+	if file := viper.GetString("config"); file != "" {
+		viper.SetConfigFile(file)
+	}
+
 	viper.AutomaticEnv()
 
-	_ = viper.ReadInConfig()
+	if err := viper.ReadInConfig(); err != nil {
+		if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
+			return errors.Wrap(err, "failed reading config")
+		}
+	}
+
+	if err := applyProfile(); err != nil {
+		return err
+	}
+
+	if err := loadSecrets(); err != nil {
+		return err
+	}
 
 	log.Info("Config initialized")
+
+	return nil
+}
+
+// applyProfile merges the selected profile from the profiles section over the rest of the config file.
+// Flags and environment variables still take precedence over the profile.
+func applyProfile() error {
+	name := viper.GetString("profile")
+	if name == "" {
+		return nil
+	}
+
+	profile := viper.GetStringMap("profiles." + name)
+	if len(profile) == 0 {
+		return errors.Errorf("profile not found: %s", name)
+	}
+
+	if err := viper.MergeConfigMap(profile); err != nil {
+		return errors.Wrapf(err, "failed applying profile %s", name)
+	}
+
+	log.Infof("Using profile: %s", name)
+
+	return nil
 }
@@ -0,0 +1,156 @@
+package config
+
+import (
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const DirectoryConfigName = ".transcoder.yaml"
+
+// DirectoryKeys are the options a directory config may set directly for the files below it.
+// A profile selected by a directory config is applied as a whole.
+var DirectoryKeys = []string{"profile", "flags", "keep-old", "skip-confidence"}
+
+// DirectoryConfig holds the overrides of a single .transcoder.yaml
+type DirectoryConfig struct {
+	Path     string
+	Settings map[string]interface{}
+
+	// Exclude patterns are relative to the directory of the config
+	Exclude []string
+}
+
+var directories = make(map[string]*DirectoryConfig)
+var directoryErrors = make(map[string]error)
+
+// base holds the value of every option before a directory config first overrode it
+var base = make(map[string]interface{})
+
+// Directory returns the config of dir, nil if it has none
+func Directory(dir string) (*DirectoryConfig, error) {
+	if directory, ok := directories[dir]; ok {
+		return directory, directoryErrors[dir]
+	}
+
+	directory, err := readDirectory(filepath.Join(dir, DirectoryConfigName))
+
+	directories[dir] = directory
+	directoryErrors[dir] = err
+
+	return directory, err
+}
+
+func readDirectory(fileName string) (*DirectoryConfig, error) {
+	if _, err := os.Stat(fileName); err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	v := viper.New()
+	v.SetConfigFile(fileName)
+
+	if err := v.ReadInConfig(); err != nil {
+		return nil, errors.Wrapf(err, "failed reading %s", fileName)
+	}
+
+	directory := &DirectoryConfig{
+		Path:     fileName,
+		Settings: make(map[string]interface{}),
+		Exclude:  v.GetStringSlice("exclude"),
+	}
+
+	if name := v.GetString("profile"); name != "" {
+		profile := viper.GetStringMap("profiles." + name)
+		if len(profile) == 0 {
+			return nil, errors.Errorf("profile not found in %s: %s", fileName, name)
+		}
+
+		for key, value := range profile {
+			directory.Settings[key] = value
+		}
+	}
+
+	keys := v.AllKeys()
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if key == "exclude" {
+			continue
+		}
+
+		if !isDirectoryKey(key) {
+			log.Warningf("Option %s cannot be set per directory in %s", key, fileName)
+			continue
+		}
+
+		directory.Settings[key] = v.Get(key)
+	}
+
+	return directory, nil
+}
+
+func isDirectoryKey(key string) bool {
+	for _, directoryKey := range DirectoryKeys {
+		if key == directoryKey {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ApplyDirectories applies the directory configs found while walking up from fileName, the closest one wins.
+// Options not overridden by any of them are reset to the values the run started with.
+func ApplyDirectories(fileName string) error {
+	chain := make([]*DirectoryConfig, 0)
+
+	for dir := filepath.Dir(fileName); ; dir = filepath.Dir(dir) {
+		directory, err := Directory(dir)
+		if err != nil {
+			return err
+		}
+
+		if directory != nil {
+			chain = append(chain, directory)
+		}
+
+		if dir == filepath.Dir(dir) {
+			break
+		}
+	}
+
+	settings := make(map[string]interface{})
+
+	for i := len(chain) - 1; i >= 0; i-- {
+		log.Debugf("Applying directory config %s", chain[i].Path)
+
+		for key, value := range chain[i].Settings {
+			settings[key] = value
+		}
+	}
+
+	for key := range settings {
+		if _, ok := base[key]; !ok {
+			base[key] = viper.Get(key)
+		}
+	}
+
+	for key, value := range base {
+		if _, ok := settings[key]; !ok {
+			viper.Set(key, value)
+		}
+	}
+
+	for key, value := range settings {
+		viper.Set(key, value)
+	}
+
+	return nil
+}
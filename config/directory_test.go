@@ -0,0 +1,101 @@
+package config
+
+import (
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestApplyDirectories(t *testing.T) {
+	viper.Reset()
+	directories = make(map[string]*DirectoryConfig)
+	directoryErrors = make(map[string]error)
+	base = make(map[string]interface{})
+
+	defer viper.Reset()
+
+	viper.Set("flags", "-c:v libx265")
+	viper.Set("keep-old", true)
+	viper.Set("container", "mkv")
+	viper.Set("profiles.anime", map[string]interface{}{
+		"flags":     "-c:v libx265 -tune animation",
+		"container": "mp4",
+	})
+
+	root := t.TempDir()
+	anime := filepath.Join(root, "Anime")
+	film := filepath.Join(root, "Film")
+	show := filepath.Join(anime, "Show")
+
+	for _, dir := range []string{show, film} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeFile(t, filepath.Join(anime, DirectoryConfigName), "profile: anime\nkeep-old: false\n")
+	writeFile(t, filepath.Join(show, DirectoryConfigName), "skip-confidence: 50\n")
+
+	tests := []struct {
+		name     string
+		file     string
+		expected map[string]interface{}
+	}{
+		{
+			name: "profile and overrides of every parent",
+			file: filepath.Join(show, "episode.mkv"),
+			expected: map[string]interface{}{
+				"profile":         "anime",
+				"flags":           "-c:v libx265 -tune animation",
+				"container":       "mp4",
+				"keep-old":        false,
+				"skip-confidence": 50,
+			},
+		},
+		{
+			name: "base values restored without directory configs",
+			file: filepath.Join(film, "movie.mkv"),
+			expected: map[string]interface{}{
+				"profile":         nil,
+				"flags":           "-c:v libx265",
+				"container":       "mkv",
+				"keep-old":        true,
+				"skip-confidence": nil,
+			},
+		},
+		{
+			name: "closest config only",
+			file: filepath.Join(anime, "movie.mkv"),
+			expected: map[string]interface{}{
+				"profile":         "anime",
+				"container":       "mp4",
+				"keep-old":        false,
+				"skip-confidence": nil,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := ApplyDirectories(test.file); err != nil {
+				t.Fatal(err)
+			}
+
+			for key, expected := range test.expected {
+				if actual := viper.Get(key); actual != expected {
+					t.Errorf("expected %s to be %v, got %v", key, expected, actual)
+				}
+			}
+		})
+	}
+}
+
+func writeFile(t *testing.T, fileName string, content string) {
+	t.Helper()
+
+	if err := ioutil.WriteFile(fileName, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,117 @@
+package config
+
+import (
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+const redacted = "********"
+
+// SecretKeys are the options holding credentials of notifiers.
+// Each of them can also be read from the file named by the <key>-file option or the <KEY>_FILE environment variable.
+var SecretKeys = []string{"tg-bot-key"}
+
+var secretValues []string
+
+// secretSources remembers the option or variable each secret read from a file was named by
+var secretSources = make(map[string]string)
+
+// IsSecret reports whether the option holds a credential
+func IsSecret(key string) bool {
+	for _, secret := range SecretKeys {
+		if key == secret {
+			return true
+		}
+	}
+
+	return false
+}
+
+// loadSecrets reads the secrets given as files and remembers all secret values for redaction
+func loadSecrets() error {
+	secretValues = nil
+
+	for _, key := range SecretKeys {
+		fileName, source := secretFile(key)
+
+		if fileName != "" {
+			if viper.GetString(key) != "" {
+				return errors.Errorf("%s is set both directly and with %s", key, source)
+			}
+
+			data, err := ioutil.ReadFile(fileName)
+			if err != nil {
+				return errors.Wrapf(err, "failed reading %s from %s", key, source)
+			}
+
+			viper.Set(key, strings.TrimSpace(string(data)))
+			secretSources[key] = "file from " + source
+			log.Debugf("Read %s from %s", key, fileName)
+		}
+
+		if value := viper.GetString(key); value != "" {
+			secretValues = append(secretValues, value)
+		}
+	}
+
+	return nil
+}
+
+// secretFile returns the file a secret should be read from and the option or variable that named it
+func secretFile(key string) (string, string) {
+	if fileName := viper.GetString(key + "-file"); fileName != "" {
+		return fileName, key + "-file"
+	}
+
+	for _, name := range []string{
+		strings.ReplaceAll(strings.ToUpper(key), "-", "_") + "_FILE",
+		strings.ToUpper(key) + "_FILE",
+	} {
+		if fileName := os.Getenv(name); fileName != "" {
+			return fileName, name
+		}
+	}
+
+	return "", ""
+}
+
+// SecretSource names where a secret that was read from a file came from, empty for all other options
+func SecretSource(key string) string {
+	return secretSources[key]
+}
+
+// Redact replaces all known secret values in the text
+func Redact(text string) string {
+	for _, secret := range secretValues {
+		text = strings.ReplaceAll(text, secret, redacted)
+	}
+
+	return text
+}
+
+// RedactValue hides the value of secret options
+func RedactValue(key string, value interface{}) interface{} {
+	if IsSecret(key) && value != "" && value != nil {
+		return redacted
+	}
+
+	return value
+}
+
+// RedactingFormatter removes secrets from log entries formatted by the wrapped formatter
+type RedactingFormatter struct {
+	log.Formatter
+}
+
+func (f RedactingFormatter) Format(entry *log.Entry) ([]byte, error) {
+	formatted, err := f.Formatter.Format(entry)
+	if err != nil || len(secretValues) == 0 {
+		return formatted, err
+	}
+
+	return []byte(Redact(string(formatted))), nil
+}
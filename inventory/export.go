@@ -0,0 +1,145 @@
+package inventory
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"strconv"
+
+	// Pure Go driver, releases are built without cgo
+	_ "modernc.org/sqlite"
+)
+
+// Write exports the items as CSV or JSON
+func Write(out io.Writer, items []Item, format string) error {
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(items)
+	case "csv":
+		return writeCSV(out, items)
+	}
+
+	return fmt.Errorf("unknown inventory format %s", format)
+}
+
+func writeCSV(out io.Writer, items []Item) error {
+	w := csv.NewWriter(out)
+
+	if err := w.Write([]string{
+		"path", "size", "container", "duration", "bitrate",
+		"video_codec", "width", "height", "resolution", "frame_rate", "pixel_format", "hdr", "transcoded",
+		"audio_codecs", "audio_languages", "subtitle_codecs", "subtitle_languages", "error",
+	}); err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		if err := w.Write([]string{
+			item.Path,
+			strconv.FormatInt(item.Size, 10),
+			item.Container,
+			strconv.FormatFloat(item.Duration, 'f', 3, 64),
+			strconv.FormatInt(item.Bitrate, 10),
+			item.VideoCodec,
+			strconv.Itoa(item.Width),
+			strconv.Itoa(item.Height),
+			item.Resolution,
+			strconv.FormatFloat(item.FrameRate, 'f', 3, 64),
+			item.PixelFmt,
+			item.HDR,
+			strconv.FormatBool(item.Transcoded),
+			Codecs(item.Audio),
+			Languages(item.Audio),
+			Codecs(item.Subtitles),
+			Languages(item.Subtitles),
+			item.Error,
+		}); err != nil {
+			return err
+		}
+	}
+
+	w.Flush()
+
+	return w.Error()
+}
+
+// WriteSQLite replaces the files and streams tables of the database with the items
+func WriteSQLite(fileName string, items []Item) error {
+	db, err := sql.Open("sqlite", fileName)
+	if err != nil {
+		return errors.Wrap(err, "failed opening database")
+	}
+
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "failed starting transaction")
+	}
+
+	defer tx.Rollback()
+
+	statements := []string{
+		`DROP TABLE IF EXISTS streams`,
+		`DROP TABLE IF EXISTS files`,
+		`CREATE TABLE files (
+			path TEXT PRIMARY KEY,
+			size INTEGER,
+			container TEXT,
+			duration REAL,
+			bitrate INTEGER,
+			video_codec TEXT,
+			width INTEGER,
+			height INTEGER,
+			resolution TEXT,
+			frame_rate REAL,
+			pixel_format TEXT,
+			hdr TEXT,
+			transcoded INTEGER,
+			error TEXT
+		)`,
+		`CREATE TABLE streams (
+			path TEXT REFERENCES files(path),
+			stream_index INTEGER,
+			type TEXT,
+			codec TEXT,
+			language TEXT,
+			channels INTEGER
+		)`,
+	}
+
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement); err != nil {
+			return errors.Wrap(err, "failed creating tables")
+		}
+	}
+
+	for _, item := range items {
+		_, err := tx.Exec(`INSERT INTO files VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			item.Path, item.Size, item.Container, item.Duration, item.Bitrate,
+			item.VideoCodec, item.Width, item.Height, item.Resolution, item.FrameRate, item.PixelFmt, item.HDR,
+			item.Transcoded, item.Error,
+		)
+		if err != nil {
+			return errors.Wrapf(err, "failed inserting %s", item.Path)
+		}
+
+		for streamType, streams := range map[string][]Stream{"audio": item.Audio, "subtitle": item.Subtitles} {
+			for _, stream := range streams {
+				_, err := tx.Exec(`INSERT INTO streams VALUES (?, ?, ?, ?, ?, ?)`,
+					item.Path, stream.Index, streamType, stream.Codec, stream.Language, stream.Channels,
+				)
+				if err != nil {
+					return errors.Wrapf(err, "failed inserting streams of %s", item.Path)
+				}
+			}
+		}
+	}
+
+	return errors.Wrap(tx.Commit(), "failed committing inventory")
+}
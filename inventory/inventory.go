@@ -0,0 +1,202 @@
+package inventory
+
+import (
+	"github.com/Vilsol/transcoder-go/cache"
+	"github.com/Vilsol/transcoder-go/models"
+	"github.com/Vilsol/transcoder-go/transcoder"
+	log "github.com/sirupsen/logrus"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Item describes a single file of the library
+type Item struct {
+	Path       string  `json:"path"`
+	Size       int64   `json:"size"`
+	Container  string  `json:"container"`
+	Duration   float64 `json:"duration"`
+	Bitrate    int64   `json:"bitrate"`
+	VideoCodec string  `json:"video_codec"`
+	Width      int     `json:"width"`
+	Height     int     `json:"height"`
+	Resolution string  `json:"resolution"`
+	FrameRate  float64 `json:"frame_rate"`
+	PixelFmt   string  `json:"pixel_format"`
+
+	// none, HDR10, HDR10+, HLG or Dolby Vision
+	HDR string `json:"hdr"`
+
+	// Whether the file carries the stamp of a transcoder output
+	Transcoded bool `json:"transcoded"`
+
+	Audio     []Stream `json:"audio"`
+	Subtitles []Stream `json:"subtitles"`
+
+	Error string `json:"error,omitempty"`
+}
+
+type Stream struct {
+	Index    int    `json:"index"`
+	Codec    string `json:"codec"`
+	Language string `json:"language"`
+	Channels int    `json:"channels,omitempty"`
+}
+
+// Scan probes all files with a bounded number of parallel ffprobe processes
+func Scan(files []string, workers int) []Item {
+	if workers < 1 {
+		workers = 1
+	}
+
+	items := make([]Item, len(files))
+
+	queue := make(chan int)
+	var wg sync.WaitGroup
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for i := range queue {
+				items[i] = scanFile(files[i])
+			}
+		}()
+	}
+
+	for i := range files {
+		queue <- i
+	}
+
+	close(queue)
+	wg.Wait()
+
+	cache.SaveAll()
+
+	return items
+}
+
+func scanFile(fileName string) Item {
+	log.Debugf("Scanning %s", fileName)
+
+	metadata, err := transcoder.ReadFileMetadata(fileName)
+	if err != nil {
+		return Item{Path: fileName, Error: err.Error()}
+	}
+
+	return FromMetadata(fileName, metadata)
+}
+
+// FromMetadata extracts the inventory fields from ffprobe output
+func FromMetadata(fileName string, metadata *models.FileMetadata) Item {
+	item := Item{
+		Path:       fileName,
+		Size:       metadata.Format.SizeInt(),
+		Container:  strings.Split(metadata.Format.FormatName, ",")[0],
+		HDR:        "none",
+		Transcoded: transcoder.IsStamped(metadata),
+		Audio:      make([]Stream, 0),
+		Subtitles:  make([]Stream, 0),
+	}
+
+	item.Duration, _ = strconv.ParseFloat(metadata.Format.Duration, 64)
+	item.Bitrate, _ = strconv.ParseInt(metadata.Format.BitRate, 10, 64)
+
+	if video := metadata.VideoStream(); video != nil {
+		item.VideoCodec = video.CodecName
+		item.Width = video.Width
+		item.Height = video.Height
+		item.Resolution = Resolution(video.Width, video.Height)
+		item.FrameRate = video.FrameRate()
+		item.HDR = HDRFormat(video)
+
+		if video.PixelFormat != nil {
+			item.PixelFmt = *video.PixelFormat
+		}
+	}
+
+	for _, stream := range metadata.Streams {
+		switch stream.CodecType {
+		case "audio":
+			item.Audio = append(item.Audio, Stream{Index: stream.Index, Codec: stream.CodecName, Language: stream.Language(), Channels: stream.Channels})
+		case "subtitle":
+			item.Subtitles = append(item.Subtitles, Stream{Index: stream.Index, Codec: stream.CodecName, Language: stream.Language()})
+		}
+	}
+
+	return item
+}
+
+// Resolution names the resolution class by the larger of width and height
+func Resolution(width int, height int) string {
+	switch {
+	case width >= 3200 || height >= 1800:
+		return "2160p"
+	case width >= 2200 || height >= 1200:
+		return "1440p"
+	case width >= 1600 || height >= 900:
+		return "1080p"
+	case width >= 1000 || height >= 600:
+		return "720p"
+	case width > 0:
+		return "SD"
+	}
+
+	return ""
+}
+
+// HDRFormat names the HDR format of the stream, Dolby Vision takes precedence over HDR10+ over HDR10
+func HDRFormat(video *models.Stream) string {
+	if video.SideData(models.SideDataDOVIConfiguration) != nil {
+		return "Dolby Vision"
+	}
+
+	if video.SideData(models.SideDataHDR10Plus) != nil {
+		return "HDR10+"
+	}
+
+	if !transcoder.IsHDR(video) {
+		return "none"
+	}
+
+	if *video.ColorTransfer == "arib-std-b67" {
+		return "HLG"
+	}
+
+	return "HDR10"
+}
+
+// Languages joins the distinct languages of the streams
+func Languages(streams []Stream) string {
+	seen := make(map[string]bool)
+	languages := make([]string, 0)
+
+	for _, stream := range streams {
+		if !seen[stream.Language] {
+			seen[stream.Language] = true
+			languages = append(languages, stream.Language)
+		}
+	}
+
+	sort.Strings(languages)
+
+	return strings.Join(languages, ";")
+}
+
+// Codecs joins the codecs of the streams in stream order
+func Codecs(streams []Stream) string {
+	codecs := make([]string, 0, len(streams))
+
+	for _, stream := range streams {
+		codec := stream.Codec
+		if stream.Channels > 0 {
+			codec += " " + strconv.Itoa(stream.Channels) + "ch"
+		}
+
+		codecs = append(codecs, codec)
+	}
+
+	return strings.Join(codecs, ";")
+}
@@ -0,0 +1,222 @@
+package joblog
+
+import (
+	"bytes"
+	"github.com/Vilsol/transcoder-go/cache"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Formatter formats the entries of job logs, it is replaced to match --log-format
+var Formatter log.Formatter = &log.TextFormatter{DisableColors: true, FullTimestamp: true}
+
+// JobLog collects our own log entries and the ffmpeg output of a single job
+type JobLog struct {
+	path   string
+	file   *os.File
+	failed bool
+	lock   sync.Mutex
+}
+
+var current *JobLog
+var currentLock sync.Mutex
+
+// Directory returns the configured job log directory, defaulting to the cache directory
+func Directory() string {
+	if dir := viper.GetString("job-log-dir"); dir != "" {
+		return dir
+	}
+
+	return filepath.Join(cache.Directory(), "jobs")
+}
+
+// Start opens the log of the job transcoding fileName, finishing the previous one
+func Start(fileName string) {
+	Finish()
+
+	if viper.GetString("job-logs") == "off" {
+		return
+	}
+
+	jobLog, err := open(fileName)
+	if err != nil {
+		log.Errorf("Error creating job log: %s", err)
+		return
+	}
+
+	currentLock.Lock()
+	current = jobLog
+	currentLock.Unlock()
+}
+
+func open(fileName string) (*JobLog, error) {
+	dir := Directory()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrap(err, "failed creating job log directory")
+	}
+
+	stem := strings.TrimSuffix(filepath.Base(fileName), filepath.Ext(fileName))
+	path := filepath.Join(dir, time.Now().Format("20060102-150405")+"-"+stem+".log")
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed opening job log")
+	}
+
+	return &JobLog{path: path, file: file}, nil
+}
+
+// Finish closes the current job log, logs of successful jobs are only kept with --job-logs all
+func Finish() {
+	currentLock.Lock()
+	jobLog := current
+	current = nil
+	currentLock.Unlock()
+
+	if jobLog == nil {
+		return
+	}
+
+	jobLog.lock.Lock()
+	err := jobLog.file.Close()
+	failed := jobLog.failed
+	jobLog.lock.Unlock()
+
+	if err != nil {
+		log.Errorf("Error closing job log %s: %s", jobLog.path, err)
+	}
+
+	if failed {
+		log.Infof("Job log kept at %s", jobLog.path)
+		return
+	}
+
+	if viper.GetString("job-logs") != "all" {
+		if err := os.Remove(jobLog.path); err != nil {
+			log.Errorf("Error removing job log %s: %s", jobLog.path, err)
+		}
+	}
+}
+
+// Active reports whether a job log is capturing the current job
+func Active() bool {
+	currentLock.Lock()
+	defer currentLock.Unlock()
+
+	return current != nil
+}
+
+// Command records an executed command line, unless trace logging already does
+func Command(name string, args []string) {
+	if log.IsLevelEnabled(log.TraceLevel) {
+		return
+	}
+
+	write(log.NewEntry(log.StandardLogger()).WithField("source", "transcoder"), log.InfoLevel, "Executing "+name+" "+strings.Join(args, " "))
+}
+
+// Stderr returns a writer turning the stderr of a process into entries of the current job log, nil without one.
+// It has to be closed once the process exited to write the last line.
+func Stderr(fields log.Fields) io.WriteCloser {
+	if !Active() {
+		return nil
+	}
+
+	return &lineWriter{entry: log.NewEntry(log.StandardLogger()).WithField("source", "ffmpeg").WithFields(fields)}
+}
+
+func write(entry *log.Entry, level log.Level, message string) {
+	currentLock.Lock()
+	jobLog := current
+	currentLock.Unlock()
+
+	if jobLog == nil {
+		return
+	}
+
+	entry = entry.Dup()
+	entry.Time = time.Now()
+	entry.Level = level
+	entry.Message = message
+
+	jobLog.write(entry)
+}
+
+func (jobLog *JobLog) write(entry *log.Entry) {
+	formatted, err := Formatter.Format(entry)
+	if err != nil {
+		return
+	}
+
+	jobLog.lock.Lock()
+	defer jobLog.lock.Unlock()
+
+	if entry.Level <= log.ErrorLevel {
+		jobLog.failed = true
+	}
+
+	_, _ = jobLog.file.Write(formatted)
+}
+
+// Hook copies our own log entries into the current job log
+type Hook struct{}
+
+func (Hook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (Hook) Fire(entry *log.Entry) error {
+	currentLock.Lock()
+	jobLog := current
+	currentLock.Unlock()
+
+	if jobLog != nil {
+		jobLog.write(entry)
+	}
+
+	return nil
+}
+
+type lineWriter struct {
+	entry  *log.Entry
+	buffer bytes.Buffer
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buffer.Write(p)
+
+	for {
+		line, err := w.buffer.ReadString('\n')
+		if err != nil {
+			// Keep the incomplete line for the next write
+			w.buffer.Reset()
+			w.buffer.WriteString(line)
+			return len(p), nil
+		}
+
+		w.writeLine(line)
+	}
+}
+
+func (w *lineWriter) Close() error {
+	if w.buffer.Len() > 0 {
+		w.writeLine(w.buffer.String())
+		w.buffer.Reset()
+	}
+
+	return nil
+}
+
+func (w *lineWriter) writeLine(line string) {
+	line = strings.TrimRight(line, "\r\n")
+	if line != "" {
+		write(w.entry, log.InfoLevel, line)
+	}
+}
@@ -6,12 +6,20 @@ type NotificationData struct {
 	Started time.Time
 
 	Filename       string
+	Path           string
+	Output         string
+	Duration       float64
 	OriginalFrames int
 	OriginalSize   int
+	Details        []string
 
 	CurrentFrame int
+	Completion   float64
 	CurrentSize  int
 	FPS          float64
 	Bitrate      float64
 	Speed        float64
+
+	// Whether the original was kept because of skip confidence rather than an output that was already larger
+	SkipConfidence bool
 }
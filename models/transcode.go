@@ -12,34 +12,89 @@ type FileMetadata struct {
 }
 
 type Stream struct {
-	CodecName      string  `json:"codec_name"`
-	CodecType      string  `json:"codec_type"`
-	PixelFormat    *string `json:"pix_fmt"`
-	Level          int     `json:"level"`
-	ColorRange     *string `json:"color_range"`
-	ColorSpace     *string `json:"color_space"`
-	ColorTransfer  *string `json:"color_transfer"`
-	ColorPrimaries *string `json:"color_primaries"`
-	NumberFrames   string  `json:"nb_frames"`
-	RFrameRate     *string `json:"r_frame_rate"`
-	AvgFrameRate   *string `json:"avg_frame_rate"`
+	Index          int               `json:"index"`
+	CodecName      string            `json:"codec_name"`
+	CodecType      string            `json:"codec_type"`
+	Width          int               `json:"width"`
+	Height         int               `json:"height"`
+	Channels       int               `json:"channels"`
+	PixelFormat    *string           `json:"pix_fmt"`
+	FieldOrder     string            `json:"field_order"`
+	Level          int               `json:"level"`
+	ColorRange     *string           `json:"color_range"`
+	ColorSpace     *string           `json:"color_space"`
+	ColorTransfer  *string           `json:"color_transfer"`
+	ColorPrimaries *string           `json:"color_primaries"`
+	NumberFrames   string            `json:"nb_frames"`
+	RFrameRate     *string           `json:"r_frame_rate"`
+	AvgFrameRate   *string           `json:"avg_frame_rate"`
+	Tags           map[string]string `json:"tags"`
+	Disposition    map[string]int    `json:"disposition"`
+	SideDataList   []SideData        `json:"side_data_list"`
 }
 
+type SideData struct {
+	SideDataType string `json:"side_data_type"`
+
+	// Mastering display metadata
+	RedX         string `json:"red_x"`
+	RedY         string `json:"red_y"`
+	GreenX       string `json:"green_x"`
+	GreenY       string `json:"green_y"`
+	BlueX        string `json:"blue_x"`
+	BlueY        string `json:"blue_y"`
+	WhitePointX  string `json:"white_point_x"`
+	WhitePointY  string `json:"white_point_y"`
+	MinLuminance string `json:"min_luminance"`
+	MaxLuminance string `json:"max_luminance"`
+
+	// Content light level metadata
+	MaxContent int `json:"max_content"`
+	MaxAverage int `json:"max_average"`
+
+	// DOVI configuration record
+	DVProfile                 int `json:"dv_profile"`
+	DVLevel                   int `json:"dv_level"`
+	RPUPresentFlag            int `json:"rpu_present_flag"`
+	ELPresentFlag             int `json:"el_present_flag"`
+	BLPresentFlag             int `json:"bl_present_flag"`
+	DVBLSignalCompatibilityID int `json:"dv_bl_signal_compatibility_id"`
+}
+
+const (
+	SideDataMasteringDisplay  = "Mastering display metadata"
+	SideDataContentLightLevel = "Content light level metadata"
+	SideDataDOVIConfiguration = "DOVI configuration record"
+	SideDataHDR10Plus         = "HDR Dynamic Metadata SMPTE2094-40 (HDR10+)"
+)
+
 type Format struct {
-	Filename   string `json:"filename"`
-	FormatName string `json:"format_name"`
-	Duration   string `json:"duration"`
-	Size       string `json:"size"`
-	BitRate    string `json:"bit_rate"`
+	Filename   string            `json:"filename"`
+	FormatName string            `json:"format_name"`
+	StartTime  string            `json:"start_time"`
+	Duration   string            `json:"duration"`
+	Size       string            `json:"size"`
+	BitRate    string            `json:"bit_rate"`
+	Tags       map[string]string `json:"tags"`
 }
 
 type ProgressReport struct {
-	Frame     int
-	FPS       float64
-	Bitrate   float64
-	TotalSize int
-	Speed     float64
-	Progress  string
+	Frame      int
+	FPS        float64
+	Bitrate    float64
+	TotalSize  int
+	OutTime    string
+	OutTimeUs  int64
+	DupFrames  int
+	DropFrames int
+	Speed      float64
+	Progress   string
+
+	// Quantizer of every output stream, keyed by "<file>_<stream>"
+	StreamQuality map[string]float64
+
+	// Every key of the block as ffmpeg reported it
+	Values map[string]string
 }
 
 type Result string
@@ -47,8 +102,12 @@ type Result string
 const (
 	ResultKeepOriginal = Result("Kept original")
 	ResultReplaced     = Result("Replaced with new")
+	ResultCopied       = Result("Written transcoded copy")
 	ResultError        = Result("Error")
 	ResultSkipped      = Result("Skipped, kept original")
+
+	// ResultUnknown is reported for files processed before the result log whose outcome cannot be told
+	ResultUnknown = Result("Unknown")
 )
 
 func (format Format) SizeInt() int64 {
@@ -56,6 +115,37 @@ func (format Format) SizeInt() int64 {
 	return int64(i)
 }
 
+// Tag looks up a container tag case-insensitively, as muxers differ in how they store tag names
+func (format Format) Tag(name string) (string, bool) {
+	for key, value := range format.Tags {
+		if strings.EqualFold(key, name) {
+			return value, true
+		}
+	}
+
+	return "", false
+}
+
+func (stream Stream) SideData(sideDataType string) *SideData {
+	for i := range stream.SideDataList {
+		if stream.SideDataList[i].SideDataType == sideDataType {
+			return &stream.SideDataList[i]
+		}
+	}
+
+	return nil
+}
+
+func (m FileMetadata) VideoStream() *Stream {
+	for i := range m.Streams {
+		if m.Streams[i].CodecType == "video" {
+			return &m.Streams[i]
+		}
+	}
+
+	return nil
+}
+
 func (stream Stream) FrameRate() float64 {
 	rate := ""
 
@@ -79,11 +169,23 @@ func (stream Stream) FrameRate() float64 {
 	return float64(a) / float64(b)
 }
 
+// Language returns the ISO 639-2 language tag of the stream or "und" if it is not set
+func (stream Stream) Language() string {
+	if language, ok := stream.Tags["language"]; ok && language != "" {
+		return language
+	}
+
+	return "und"
+}
+
 func (report *ProgressReport) Log(filename string) {
 	log.WithField("frame", report.Frame).
 		WithField("fps", report.FPS).
 		WithField("bitrate", report.Bitrate).
 		WithField("total_size", report.TotalSize).
+		WithField("out_time", report.OutTime).
+		WithField("dup_frames", report.DupFrames).
+		WithField("drop_frames", report.DropFrames).
 		WithField("speed", report.Speed).
 		Infof("Progress: %s", filename)
 }
@@ -105,3 +207,37 @@ func (m FileMetadata) Frames() int {
 
 	return frames
 }
+
+// Completion returns how much of the file the report covers in percent,
+// by output time when ffmpeg reports it and by frames otherwise
+func (m FileMetadata) Completion(report *ProgressReport) float64 {
+	if report == nil {
+		return 0
+	}
+
+	duration, _ := strconv.ParseFloat(m.Format.Duration, 64)
+	if report.OutTimeUs > 0 && duration > 0 {
+		return float64(report.OutTimeUs) / (duration * 1000000) * 100
+	}
+
+	if frames := m.Frames(); frames > 0 {
+		return float64(report.Frame) / float64(frames) * 100
+	}
+
+	return 0
+}
+
+// ResultCompletion returns how much of the file a finished output covers in percent, by duration with frames as fallback
+func (m FileMetadata) ResultCompletion(result *FileMetadata) float64 {
+	duration, _ := strconv.ParseFloat(m.Format.Duration, 64)
+	resultDuration, _ := strconv.ParseFloat(result.Format.Duration, 64)
+	if duration > 0 && resultDuration > 0 {
+		return resultDuration / duration * 100
+	}
+
+	if frames := m.Frames(); frames > 0 {
+		return float64(result.Frames()) / float64(frames) * 100
+	}
+
+	return 0
+}
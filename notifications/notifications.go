@@ -19,6 +19,8 @@ var end []End
 
 var started time.Time
 var currentFileMetadata *models.FileMetadata
+var currentOutput string
+var currentDetails []string
 
 var skipChan chan bool
 
@@ -28,8 +30,10 @@ func InitializeNotifications() {
 	}
 }
 
-func NotifyStart(metadata *models.FileMetadata) {
+func NotifyStart(metadata *models.FileMetadata, output string, details []string) {
 	currentFileMetadata = metadata
+	currentOutput = output
+	currentDetails = details
 	started = time.Now()
 
 	notificationData := generateUpdatedNotificationData(nil)
@@ -47,7 +51,17 @@ func NotifyProgressStatus(report *models.ProgressReport) {
 }
 
 func NotifyEnd(finalMeta *models.FileMetadata, lastReport *models.ProgressReport, result models.Result) {
+	notifyEnd(finalMeta, lastReport, result, false)
+}
+
+// NotifySkipConfidenceEnd ends a job whose original was kept because of skip confidence
+func NotifySkipConfidenceEnd(finalMeta *models.FileMetadata, lastReport *models.ProgressReport) {
+	notifyEnd(finalMeta, lastReport, models.ResultKeepOriginal, true)
+}
+
+func notifyEnd(finalMeta *models.FileMetadata, lastReport *models.ProgressReport, result models.Result, skipConfidence bool) {
 	notificationData := generateUpdatedNotificationData(lastReport)
+	notificationData.SkipConfidence = skipConfidence
 
 	if finalMeta != nil {
 		notificationData.CurrentSize, _ = strconv.Atoi(finalMeta.Format.Size)
@@ -65,6 +79,8 @@ func NotifyEnd(finalMeta *models.FileMetadata, lastReport *models.ProgressReport
 			duration, _ := strconv.ParseFloat(finalMeta.Format.Duration, 64)
 			notificationData.CurrentFrame = int(framerate * duration)
 		}
+
+		notificationData.Completion = currentFileMetadata.ResultCompletion(finalMeta)
 	}
 
 	for _, f := range end {
@@ -76,8 +92,13 @@ func generateUpdatedNotificationData(report *models.ProgressReport) *models.Noti
 	data := models.NotificationData{
 		Started:  started,
 		Filename: filepath.Base(currentFileMetadata.Format.Filename),
+		Path:     currentFileMetadata.Format.Filename,
+		Output:   currentOutput,
+		Details:  currentDetails,
 	}
 
+	data.Duration, _ = strconv.ParseFloat(currentFileMetadata.Format.Duration, 64)
+
 	data.OriginalSize, _ = strconv.Atoi(currentFileMetadata.Format.Size)
 	framerate := float64(0)
 
@@ -100,6 +121,7 @@ func generateUpdatedNotificationData(report *models.ProgressReport) *models.Noti
 		data.FPS = report.FPS
 		data.CurrentFrame = report.Frame
 		data.CurrentSize = report.TotalSize
+		data.Completion = currentFileMetadata.Completion(report)
 	}
 
 	return &data
@@ -0,0 +1,38 @@
+package notifications
+
+import (
+	"github.com/Vilsol/transcoder-go/models"
+	"github.com/Vilsol/transcoder-go/report"
+	"github.com/Vilsol/transcoder-go/stats"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"time"
+)
+
+func init() {
+	initialize = append(initialize, func() {
+		if viper.GetBool("no-result-log") {
+			return
+		}
+
+		end = append(end, func(data *models.NotificationData, result models.Result) {
+			entry := report.Entry{
+				Time:           time.Now(),
+				Path:           data.Path,
+				Output:         data.Output,
+				Profile:        stats.Profile(),
+				Result:         result,
+				InputSize:      int64(data.OriginalSize),
+				OutputSize:     int64(data.CurrentSize),
+				Completion:     data.Completion,
+				Duration:       data.Duration,
+				EncodeTime:     time.Since(data.Started).Seconds(),
+				SkipConfidence: data.SkipConfidence,
+			}
+
+			if err := report.Append(entry); err != nil {
+				log.Errorf("Error writing result log: %s", err)
+			}
+		})
+	})
+}
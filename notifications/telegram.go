@@ -155,7 +155,7 @@ func generateTelegramMessageText(data *models.NotificationData, result *models.R
 		)
 	}
 
-	complete := (float64(data.CurrentFrame) / float64(data.OriginalFrames)) * 100
+	complete := data.Completion
 
 	skipConfidence := 0.0
 	expected := "0b"
@@ -166,6 +166,11 @@ func generateTelegramMessageText(data *models.NotificationData, result *models.R
 		skipConfidence = utils.SkipConfidence(data.OriginalSize, data.CurrentSize, complete)
 	}
 
+	details := ""
+	for _, detail := range data.Details {
+		details += "\n" + detail
+	}
+
 	return fmt.Sprintf(
 		"*%s*"+
 			"\n*Size:* %s --> %s (%.2f%%)"+
@@ -173,7 +178,8 @@ func generateTelegramMessageText(data *models.NotificationData, result *models.R
 			"\n*Expected Size:* %s"+
 			"\n*ETA:* %s"+
 			"\n*FPS:* %.2f"+
-			"\n*Skip Confidence:* %.2f",
+			"\n*Skip Confidence:* %.2f"+
+			"%s",
 		data.Filename,
 		utils.BytesHumanReadable(int64(data.OriginalSize)), utils.BytesHumanReadable(int64(data.CurrentSize)), diff,
 		complete,
@@ -181,5 +187,6 @@ func generateTelegramMessageText(data *models.NotificationData, result *models.R
 		eta.Truncate(time.Second),
 		data.FPS,
 		skipConfidence,
+		details,
 	)
 }
@@ -0,0 +1,147 @@
+package report
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/Vilsol/transcoder-go/models"
+	"github.com/Vilsol/transcoder-go/utils"
+	"io"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+)
+
+// Write renders the summary as a table, JSON or CSV
+func Write(out io.Writer, summary Summary, format string) error {
+	switch format {
+	case "table":
+		return writeTable(out, summary)
+	case "json":
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(summary)
+	case "csv":
+		return writeCSV(out, summary)
+	}
+
+	return fmt.Errorf("unknown report format %s", format)
+}
+
+func writeTable(out io.Writer, summary Summary) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintf(w, "Files:\t%d\n", summary.Total.Files)
+	fmt.Fprintf(w, "Before:\t%s\n", utils.BytesHumanReadable(summary.Total.Before))
+	fmt.Fprintf(w, "After:\t%s\n", utils.BytesHumanReadable(summary.Total.After))
+	fmt.Fprintf(w, "Saved:\t%s (%.2f%%)\n", utils.BytesHumanReadable(summary.Total.Saved), percentage(summary.Total.Saved, summary.Total.Before))
+	fmt.Fprintf(w, "Average speed:\t%.2fx\n", summary.AverageSpeed)
+	fmt.Fprintf(w, "Kept by skip confidence:\t%d\n", summary.SkipConfidence)
+	if summary.Legacy > 0 {
+		fmt.Fprintf(w, "Without original size:\t%d\n", summary.Legacy)
+	}
+
+	fmt.Fprintln(w, "\nResult\tFiles")
+	for _, result := range sortedResults(summary) {
+		fmt.Fprintf(w, "%s\t%d\n", result, summary.Results[result])
+	}
+
+	fmt.Fprintln(w, "\nDirectory\tFiles\tBefore\tAfter\tSaved")
+	for _, directory := range summary.Directories {
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s (%.2f%%)\n",
+			directory.Directory,
+			directory.Files,
+			utils.BytesHumanReadable(directory.Before),
+			utils.BytesHumanReadable(directory.After),
+			utils.BytesHumanReadable(directory.Saved),
+			percentage(directory.Saved, directory.Before),
+		)
+	}
+
+	if len(summary.Worst) > 0 {
+		fmt.Fprintln(w, "\nWorst offenders\tResult\tOriginal\tOutput\tRatio")
+		for _, offender := range summary.Worst {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%.2f\n",
+				offender.Path,
+				offender.Result,
+				utils.BytesHumanReadable(offender.InputSize),
+				utils.BytesHumanReadable(offender.OutputSize),
+				offender.Ratio,
+			)
+		}
+	}
+
+	return w.Flush()
+}
+
+// writeCSV writes one row per summarized item, the section column tells them apart
+func writeCSV(out io.Writer, summary Summary) error {
+	w := csv.NewWriter(out)
+
+	rows := [][]string{
+		{"section", "name", "files", "bytes_before", "bytes_after", "bytes_saved", "ratio"},
+		totalsRow("total", "", summary.Total),
+		{"speed", "average", "", "", "", "", strconv.FormatFloat(summary.AverageSpeed, 'f', 4, 64)},
+		{"skip_confidence", "kept", strconv.Itoa(summary.SkipConfidence), "", "", "", ""},
+		{"legacy", "without_original_size", strconv.Itoa(summary.Legacy), "", "", "", ""},
+	}
+
+	for _, result := range sortedResults(summary) {
+		rows = append(rows, []string{"result", string(result), strconv.Itoa(summary.Results[result]), "", "", "", ""})
+	}
+
+	for _, directory := range summary.Directories {
+		rows = append(rows, totalsRow("directory", directory.Directory, directory.Totals))
+	}
+
+	for _, offender := range summary.Worst {
+		rows = append(rows, []string{
+			"worst",
+			offender.Path,
+			"1",
+			strconv.FormatInt(offender.InputSize, 10),
+			strconv.FormatInt(offender.OutputSize, 10),
+			"",
+			strconv.FormatFloat(offender.Ratio, 'f', 4, 64),
+		})
+	}
+
+	if err := w.WriteAll(rows); err != nil {
+		return err
+	}
+
+	return w.Error()
+}
+
+func totalsRow(section string, name string, totals Totals) []string {
+	return []string{
+		section,
+		name,
+		strconv.Itoa(totals.Files),
+		strconv.FormatInt(totals.Before, 10),
+		strconv.FormatInt(totals.After, 10),
+		strconv.FormatInt(totals.Saved, 10),
+		"",
+	}
+}
+
+func sortedResults(summary Summary) []models.Result {
+	results := make([]models.Result, 0, len(summary.Results))
+	for result := range summary.Results {
+		results = append(results, result)
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i] < results[j]
+	})
+
+	return results
+}
+
+func percentage(part int64, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+
+	return float64(part) / float64(total) * 100
+}
@@ -0,0 +1,112 @@
+package report
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/Vilsol/transcoder-go/cache"
+	"github.com/Vilsol/transcoder-go/models"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Entry is one line of the result log, written whenever a job ends
+type Entry struct {
+	Time    time.Time     `json:"time"`
+	Path    string        `json:"path"`
+	Profile string        `json:"profile"`
+	Result  models.Result `json:"result"`
+
+	// Path the output was or would have been written to, the processed sidecar is named after it
+	Output string `json:"output,omitempty"`
+
+	// Size of the original, 0 if unknown
+	InputSize int64 `json:"input_size"`
+
+	// Size of the output, or of the partial output if the encode was stopped early
+	OutputSize int64 `json:"output_size"`
+
+	// Percentage of the file that was encoded
+	Completion float64 `json:"completion"`
+
+	// Seconds of media in the original and seconds spent encoding it
+	Duration   float64 `json:"duration"`
+	EncodeTime float64 `json:"encode_time"`
+
+	// Whether the original was kept because of skip confidence rather than an output that was already larger
+	SkipConfidence bool `json:"skip_confidence,omitempty"`
+
+	// Whether the entry was reconstructed from a processed sidecar of a run without a result log
+	Legacy bool `json:"legacy,omitempty"`
+}
+
+var logLock sync.Mutex
+
+// LogPath returns the configured result log, defaulting to the cache directory
+func LogPath() string {
+	if path := viper.GetString("result-log"); path != "" {
+		return path
+	}
+
+	return filepath.Join(cache.Directory(), "results.jsonl")
+}
+
+// Append writes the entry as a single JSON line
+func Append(entry Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return errors.Wrap(err, "failed encoding result")
+	}
+
+	logLock.Lock()
+	defer logLock.Unlock()
+
+	path := LogPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return errors.Wrap(err, "failed creating result log directory")
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.Wrap(err, "failed opening result log")
+	}
+
+	defer file.Close()
+
+	_, err = file.Write(append(data, '\n'))
+
+	return errors.Wrap(err, "failed writing result log")
+}
+
+// ReadLog returns all entries of the result log, skipping lines that cannot be parsed
+func ReadLog() ([]Entry, error) {
+	entries := make([]Entry, 0)
+
+	file, err := os.Open(LogPath())
+	if os.IsNotExist(err) {
+		return entries, nil
+	}
+
+	if err != nil {
+		return nil, errors.Wrap(err, "failed opening result log")
+	}
+
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			log.Warningf("Skipping unreadable result log line: %s", err)
+			continue
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, errors.Wrap(scanner.Err(), "failed reading result log")
+}
@@ -0,0 +1,148 @@
+package report
+
+import (
+	"github.com/Vilsol/transcoder-go/models"
+	"path/filepath"
+	"sort"
+)
+
+type Totals struct {
+	Files  int   `json:"files"`
+	Before int64 `json:"bytes_before"`
+	After  int64 `json:"bytes_after"`
+	Saved  int64 `json:"bytes_saved"`
+}
+
+type DirectoryTotals struct {
+	Directory string `json:"directory"`
+	Totals
+}
+
+type Offender struct {
+	Path       string        `json:"path"`
+	Result     models.Result `json:"result"`
+	InputSize  int64         `json:"input_size"`
+	OutputSize int64         `json:"output_size"`
+
+	// Output/input size ratio, extrapolated for encodes that were stopped early
+	Ratio float64 `json:"ratio"`
+}
+
+type Summary struct {
+	Total       Totals                `json:"total"`
+	Directories []DirectoryTotals     `json:"directories"`
+	Results     map[models.Result]int `json:"results"`
+
+	// Mean of media seconds encoded per second spent
+	AverageSpeed float64 `json:"average_speed"`
+
+	// Originals kept because of skip confidence
+	SkipConfidence int `json:"skip_confidence"`
+
+	// Files only known from processed sidecars, their original size is unknown
+	Legacy int `json:"legacy"`
+
+	Worst []Offender `json:"worst"`
+}
+
+// Summarize aggregates the latest entry of every file
+func Summarize(entries []Entry, worst int) Summary {
+	latest := make(map[string]Entry)
+	for _, entry := range entries {
+		if existing, ok := latest[entry.Path]; !ok || !entry.Time.Before(existing.Time) {
+			latest[entry.Path] = entry
+		}
+	}
+
+	summary := Summary{
+		Results: make(map[models.Result]int),
+		Worst:   make([]Offender, 0),
+	}
+
+	directories := make(map[string]*Totals)
+	speeds := 0.0
+	speedCount := 0
+
+	for _, entry := range latest {
+		summary.Results[entry.Result]++
+
+		if entry.SkipConfidence {
+			summary.SkipConfidence++
+		}
+
+		if entry.Legacy {
+			summary.Legacy++
+		}
+
+		if entry.EncodeTime > 0 && entry.Duration > 0 && entry.Completion > 0 {
+			speeds += entry.Duration * entry.Completion / 100 / entry.EncodeTime
+			speedCount++
+		}
+
+		if entry.InputSize <= 0 {
+			continue
+		}
+
+		after := entry.InputSize
+		if entry.Result == models.ResultReplaced || entry.Result == models.ResultCopied {
+			after = entry.OutputSize
+		}
+
+		directory := filepath.Dir(entry.Path)
+		if directories[directory] == nil {
+			directories[directory] = &Totals{}
+		}
+
+		for _, totals := range []*Totals{&summary.Total, directories[directory]} {
+			totals.Files++
+			totals.Before += entry.InputSize
+			totals.After += after
+			totals.Saved += entry.InputSize - after
+		}
+
+		if ratio := projectedRatio(entry); ratio > 0 {
+			summary.Worst = append(summary.Worst, Offender{
+				Path:       entry.Path,
+				Result:     entry.Result,
+				InputSize:  entry.InputSize,
+				OutputSize: entry.OutputSize,
+				Ratio:      ratio,
+			})
+		}
+	}
+
+	if speedCount > 0 {
+		summary.AverageSpeed = speeds / float64(speedCount)
+	}
+
+	for directory, totals := range directories {
+		summary.Directories = append(summary.Directories, DirectoryTotals{Directory: directory, Totals: *totals})
+	}
+
+	sort.Slice(summary.Directories, func(i, j int) bool {
+		return summary.Directories[i].Saved > summary.Directories[j].Saved
+	})
+
+	sort.Slice(summary.Worst, func(i, j int) bool {
+		return summary.Worst[i].Ratio > summary.Worst[j].Ratio
+	})
+
+	if len(summary.Worst) > worst {
+		summary.Worst = summary.Worst[:worst]
+	}
+
+	return summary
+}
+
+func projectedRatio(entry Entry) float64 {
+	if entry.OutputSize <= 0 || entry.InputSize <= 0 {
+		return 0
+	}
+
+	size := float64(entry.OutputSize)
+	if entry.Completion > 0 && entry.Completion < 100 {
+		size = size * 100 / entry.Completion
+	}
+
+	return size / float64(entry.InputSize)
+}
@@ -0,0 +1,105 @@
+package report
+
+import (
+	"github.com/Vilsol/transcoder-go/models"
+	"testing"
+	"time"
+)
+
+func TestSummarize(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	entries := []Entry{
+		{Time: start, Path: "/tv/a.mkv", Result: models.ResultError, InputSize: 1000},
+		{Time: start.Add(time.Hour), Path: "/tv/a.mkv", Result: models.ResultReplaced, InputSize: 1000, OutputSize: 400, Completion: 100, Duration: 600, EncodeTime: 300},
+		{Time: start, Path: "/tv/b.mkv", Result: models.ResultKeepOriginal, InputSize: 1000, OutputSize: 500, Completion: 50, Duration: 600, EncodeTime: 100, SkipConfidence: true},
+		{Time: start, Path: "/movies/c.mkv", Result: models.ResultCopied, InputSize: 2000, OutputSize: 500, Completion: 100},
+		{Time: start, Path: "/movies/d.mkv", Result: models.ResultUnknown, Legacy: true},
+	}
+
+	summary := Summarize(entries, 2)
+
+	expectedTotal := Totals{Files: 3, Before: 4000, After: 1900, Saved: 2100}
+	if summary.Total != expectedTotal {
+		t.Errorf("expected %+v, got %+v", expectedTotal, summary.Total)
+	}
+
+	expectedDirectories := []DirectoryTotals{
+		{Directory: "/movies", Totals: Totals{Files: 1, Before: 2000, After: 500, Saved: 1500}},
+		{Directory: "/tv", Totals: Totals{Files: 2, Before: 2000, After: 1400, Saved: 600}},
+	}
+	if len(summary.Directories) != len(expectedDirectories) {
+		t.Fatalf("expected %+v, got %+v", expectedDirectories, summary.Directories)
+	}
+	for i := range expectedDirectories {
+		if summary.Directories[i] != expectedDirectories[i] {
+			t.Errorf("expected %+v, got %+v", expectedDirectories, summary.Directories)
+			break
+		}
+	}
+
+	expectedResults := map[models.Result]int{
+		models.ResultReplaced:     1,
+		models.ResultKeepOriginal: 1,
+		models.ResultCopied:       1,
+		models.ResultUnknown:      1,
+	}
+	if len(summary.Results) != len(expectedResults) {
+		t.Errorf("expected %v, got %v", expectedResults, summary.Results)
+	}
+	for result, count := range expectedResults {
+		if summary.Results[result] != count {
+			t.Errorf("expected %v, got %v", expectedResults, summary.Results)
+			break
+		}
+	}
+
+	if summary.SkipConfidence != 1 {
+		t.Errorf("expected 1 skip confidence, got %d", summary.SkipConfidence)
+	}
+
+	if summary.Legacy != 1 {
+		t.Errorf("expected 1 legacy, got %d", summary.Legacy)
+	}
+
+	// a.mkv encoded 600s in 300s, b.mkv encoded 300s in 100s
+	if summary.AverageSpeed != 2.5 {
+		t.Errorf("expected an average speed of 2.5, got %f", summary.AverageSpeed)
+	}
+
+	expectedWorst := []Offender{
+		{Path: "/tv/b.mkv", Result: models.ResultKeepOriginal, InputSize: 1000, OutputSize: 500, Ratio: 1},
+		{Path: "/tv/a.mkv", Result: models.ResultReplaced, InputSize: 1000, OutputSize: 400, Ratio: 0.4},
+	}
+	if len(summary.Worst) != len(expectedWorst) {
+		t.Fatalf("expected %+v, got %+v", expectedWorst, summary.Worst)
+	}
+	for i := range expectedWorst {
+		if summary.Worst[i] != expectedWorst[i] {
+			t.Errorf("expected %+v, got %+v", expectedWorst, summary.Worst)
+			break
+		}
+	}
+}
+
+func TestProjectedRatio(t *testing.T) {
+	tests := []struct {
+		name     string
+		entry    Entry
+		expected float64
+	}{
+		{name: "complete", entry: Entry{InputSize: 1000, OutputSize: 400, Completion: 100}, expected: 0.4},
+		{name: "stopped early", entry: Entry{InputSize: 1000, OutputSize: 300, Completion: 25}, expected: 1.2},
+		{name: "unknown completion", entry: Entry{InputSize: 1000, OutputSize: 400}, expected: 0.4},
+		{name: "no output", entry: Entry{InputSize: 1000}, expected: 0},
+		{name: "unknown input size", entry: Entry{OutputSize: 400, Completion: 100}, expected: 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := projectedRatio(test.entry); actual != test.expected {
+				t.Errorf("expected %f, got %f", test.expected, actual)
+			}
+		})
+	}
+}
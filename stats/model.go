@@ -0,0 +1,162 @@
+package stats
+
+import (
+	"github.com/pkg/errors"
+	"math"
+	"sort"
+)
+
+// Ridge penalty that keeps the fit solvable with few or correlated samples
+const regularization = 0.01
+
+// Model is a linear regression of the log output/input size ratio on the input characteristics
+type Model struct {
+	Samples    int
+	Codecs     []string
+	Containers []string
+	Weights    []float64
+}
+
+// Fit solves the regularized normal equations over all records
+func Fit(records []Record) (*Model, error) {
+	if len(records) == 0 {
+		return nil, errors.New("no records to fit")
+	}
+
+	model := &Model{
+		Samples:    len(records),
+		Codecs:     uniqueValues(records, func(record Record) string { return record.Codec }),
+		Containers: uniqueValues(records, func(record Record) string { return record.Container }),
+	}
+
+	size := len(model.features(records[0]))
+
+	// A = XᵀX + λI, b = Xᵀy
+	a := make([][]float64, size)
+	for i := range a {
+		a[i] = make([]float64, size)
+	}
+	b := make([]float64, size)
+
+	for _, record := range records {
+		x := model.features(record)
+		y := math.Log(record.Ratio)
+
+		for i := 0; i < size; i++ {
+			for j := 0; j < size; j++ {
+				a[i][j] += x[i] * x[j]
+			}
+			b[i] += x[i] * y
+		}
+	}
+
+	// The intercept is not penalized
+	for i := 1; i < size; i++ {
+		a[i][i] += regularization * float64(len(records))
+	}
+
+	weights, err := solve(a, b)
+	if err != nil {
+		return nil, err
+	}
+
+	model.Weights = weights
+
+	return model, nil
+}
+
+// Predict returns the expected output/input size ratio
+func (model *Model) Predict(record Record) float64 {
+	x := model.features(record)
+
+	sum := 0.0
+	for i, weight := range model.Weights {
+		sum += weight * x[i]
+	}
+
+	return math.Exp(sum)
+}
+
+// features are the intercept, log bits per pixel, log pixels, framerate and one-hot codec and container
+func (model *Model) features(record Record) []float64 {
+	x := []float64{
+		1,
+		math.Log(math.Max(record.BitsPerPixel, 1e-6)),
+		math.Log(math.Max(float64(record.Width*record.Height), 1)),
+		record.FrameRate / 30,
+	}
+
+	for _, codec := range model.Codecs {
+		x = append(x, indicator(record.Codec == codec))
+	}
+
+	for _, container := range model.Containers {
+		x = append(x, indicator(record.Container == container))
+	}
+
+	return x
+}
+
+func indicator(value bool) float64 {
+	if value {
+		return 1
+	}
+
+	return 0
+}
+
+func uniqueValues(records []Record, value func(Record) string) []string {
+	seen := make(map[string]bool)
+	values := make([]string, 0)
+
+	for _, record := range records {
+		if v := value(record); !seen[v] {
+			seen[v] = true
+			values = append(values, v)
+		}
+	}
+
+	sort.Strings(values)
+
+	return values
+}
+
+// solve runs Gaussian elimination with partial pivoting on a square system
+func solve(a [][]float64, b []float64) ([]float64, error) {
+	n := len(b)
+
+	for column := 0; column < n; column++ {
+		pivot := column
+		for row := column + 1; row < n; row++ {
+			if math.Abs(a[row][column]) > math.Abs(a[pivot][column]) {
+				pivot = row
+			}
+		}
+
+		if math.Abs(a[pivot][column]) < 1e-12 {
+			return nil, errors.New("size model is singular")
+		}
+
+		a[column], a[pivot] = a[pivot], a[column]
+		b[column], b[pivot] = b[pivot], b[column]
+
+		for row := column + 1; row < n; row++ {
+			factor := a[row][column] / a[column][column]
+			for k := column; k < n; k++ {
+				a[row][k] -= factor * a[column][k]
+			}
+			b[row] -= factor * b[column]
+		}
+	}
+
+	x := make([]float64, n)
+	for row := n - 1; row >= 0; row-- {
+		sum := b[row]
+		for k := row + 1; k < n; k++ {
+			sum -= a[row][k] * x[k]
+		}
+		x[row] = sum / a[row][row]
+	}
+
+	return x, nil
+}
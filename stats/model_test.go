@@ -0,0 +1,132 @@
+package stats
+
+import (
+	"math"
+	"testing"
+)
+
+func TestFit(t *testing.T) {
+	tests := []struct {
+		name      string
+		records   []Record
+		query     Record
+		expected  float64
+		tolerance float64
+	}{
+		{
+			name: "constant ratio",
+			records: []Record{
+				{Codec: "h264", Container: "matroska", Width: 1920, Height: 1080, FrameRate: 24, BitsPerPixel: 0.1, Ratio: 0.4},
+				{Codec: "h264", Container: "matroska", Width: 1280, Height: 720, FrameRate: 30, BitsPerPixel: 0.2, Ratio: 0.4},
+				{Codec: "h264", Container: "matroska", Width: 3840, Height: 2160, FrameRate: 60, BitsPerPixel: 0.05, Ratio: 0.4},
+			},
+			query:     Record{Codec: "h264", Container: "matroska", Width: 1920, Height: 1080, FrameRate: 24, BitsPerPixel: 0.1},
+			expected:  0.4,
+			tolerance: 0.01,
+		},
+		{
+			name:      "ratio proportional to the bits per pixel",
+			records:   bitrateRecords("h264", 0.5),
+			query:     Record{Codec: "h264", Container: "matroska", Width: 1920, Height: 1080, FrameRate: 24, BitsPerPixel: 0.15},
+			expected:  0.5 * 0.15,
+			tolerance: 0.05,
+		},
+		{
+			name:      "codecs learned separately",
+			records:   append(bitrateRecords("h264", 0.5), bitrateRecords("mpeg2video", 2)...),
+			query:     Record{Codec: "mpeg2video", Container: "matroska", Width: 1920, Height: 1080, FrameRate: 24, BitsPerPixel: 0.15},
+			expected:  2 * 0.15,
+			tolerance: 0.1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			model, err := Fit(test.records)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if model.Samples != len(test.records) {
+				t.Errorf("expected %d samples, got %d", len(test.records), model.Samples)
+			}
+
+			actual := model.Predict(test.query)
+			if math.Abs(actual-test.expected)/test.expected > test.tolerance {
+				t.Errorf("expected %f, got %f", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestFitNoRecords(t *testing.T) {
+	if _, err := Fit(nil); err == nil {
+		t.Fatal("expected an error without records")
+	}
+}
+
+func TestSolve(t *testing.T) {
+	tests := []struct {
+		name     string
+		a        [][]float64
+		b        []float64
+		expected []float64
+		ok       bool
+	}{
+		{
+			name:     "diagonal",
+			a:        [][]float64{{2, 0}, {0, 4}},
+			b:        []float64{2, 8},
+			expected: []float64{1, 2},
+			ok:       true,
+		},
+		{
+			name:     "needs pivoting",
+			a:        [][]float64{{0, 1, 1}, {2, 1, 0}, {1, 0, 3}},
+			b:        []float64{5, 4, 10},
+			expected: []float64{1, 2, 3},
+			ok:       true,
+		},
+		{
+			name: "singular",
+			a:    [][]float64{{1, 2}, {2, 4}},
+			b:    []float64{3, 6},
+			ok:   false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual, err := solve(test.a, test.b)
+			if (err == nil) != test.ok {
+				t.Fatalf("expected ok %v, got error %v", test.ok, err)
+			}
+
+			for i := range test.expected {
+				if math.Abs(actual[i]-test.expected[i]) > 1e-9 {
+					t.Errorf("expected %v, got %v", test.expected, actual)
+					break
+				}
+			}
+		})
+	}
+}
+
+// bitrateRecords returns 1080p records of a codec whose output is the given fraction of the bits per pixel
+func bitrateRecords(codec string, factor float64) []Record {
+	records := make([]Record, 0)
+
+	for _, bitsPerPixel := range []float64{0.05, 0.1, 0.2, 0.3, 0.4} {
+		records = append(records, Record{
+			Codec:        codec,
+			Container:    "matroska",
+			Width:        1920,
+			Height:       1080,
+			FrameRate:    24,
+			BitsPerPixel: bitsPerPixel,
+			Ratio:        factor * bitsPerPixel,
+		})
+	}
+
+	return records
+}
@@ -0,0 +1,131 @@
+package stats
+
+import (
+	"github.com/Vilsol/transcoder-go/cache"
+	"github.com/Vilsol/transcoder-go/models"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Record is what is remembered about a finished job to learn output sizes from
+type Record struct {
+	Profile      string    `json:"profile"`
+	Codec        string    `json:"codec"`
+	Container    string    `json:"container"`
+	Width        int       `json:"width"`
+	Height       int       `json:"height"`
+	FrameRate    float64   `json:"frame_rate"`
+	BitsPerPixel float64   `json:"bits_per_pixel"`
+	InputSize    int64     `json:"input_size"`
+	OutputSize   int64     `json:"output_size"`
+	Ratio        float64   `json:"ratio"`
+	Time         time.Time `json:"time"`
+
+	// The output size was extrapolated from an encode that was stopped early
+	Estimated bool `json:"estimated,omitempty"`
+}
+
+// Profile returns the name records of the current run are stored under
+func Profile() string {
+	if profile := viper.GetString("profile"); profile != "" {
+		return profile
+	}
+
+	return "default"
+}
+
+// FromMetadata extracts the input characteristics of a file, returns false if the file lacks them
+func FromMetadata(metadata *models.FileMetadata) (Record, bool) {
+	video := metadata.VideoStream()
+	if video == nil || video.Width == 0 || video.Height == 0 {
+		return Record{}, false
+	}
+
+	duration, _ := strconv.ParseFloat(metadata.Format.Duration, 64)
+	frameRate := video.FrameRate()
+	size := metadata.Format.SizeInt()
+
+	if duration <= 0 || frameRate <= 0 || size <= 0 {
+		return Record{}, false
+	}
+
+	bitrate := float64(size) * 8 / duration
+
+	return Record{
+		Profile:      Profile(),
+		Codec:        video.CodecName,
+		Container:    strings.Split(metadata.Format.FormatName, ",")[0],
+		Width:        video.Width,
+		Height:       video.Height,
+		FrameRate:    frameRate,
+		BitsPerPixel: bitrate / (float64(video.Width*video.Height) * frameRate),
+		InputSize:    size,
+	}, true
+}
+
+// RecordJob stores the outcome of a finished job
+func RecordJob(fileName string, original *models.FileMetadata, result *models.FileMetadata) {
+	store(fileName, original, result.Format.SizeInt(), false)
+}
+
+// RecordEstimate stores the expected output size of a job that was stopped before it finished.
+// Early exit stops the encodes that would grow, without them the model would rarely predict growth.
+func RecordEstimate(fileName string, original *models.FileMetadata, outputSize int64) {
+	store(fileName, original, outputSize, true)
+}
+
+func store(fileName string, original *models.FileMetadata, outputSize int64, estimated bool) {
+	record, ok := FromMetadata(original)
+	if !ok || outputSize <= 0 {
+		return
+	}
+
+	record.OutputSize = outputSize
+	record.Estimated = estimated
+	record.Ratio = float64(record.OutputSize) / float64(record.InputSize)
+	record.Time = time.Now()
+
+	absPath, err := filepath.Abs(fileName)
+	if err != nil {
+		absPath = fileName
+	}
+
+	if err := cache.Open("history").Set(record.Profile+"|"+absPath, record); err != nil {
+		log.Errorf("Error recording outcome of %s: %s", fileName, err)
+	}
+}
+
+// Records returns all stored outcomes of the profile
+func Records(profile string) []Record {
+	store := cache.Open("history")
+
+	records := make([]Record, 0)
+	for _, key := range store.Keys() {
+		if !strings.HasPrefix(key, profile+"|") {
+			continue
+		}
+
+		var record Record
+		if store.Get(key, &record) && record.Ratio > 0 {
+			records = append(records, record)
+		}
+	}
+
+	return records
+}
+
+// LoadModel fits a model to the stored outcomes of the current profile
+func LoadModel() (*Model, error) {
+	records := Records(Profile())
+
+	if len(records) < viper.GetInt("predict-min-samples") {
+		return nil, errors.Errorf("%d of %d required finished jobs recorded for profile %s", len(records), viper.GetInt("predict-min-samples"), Profile())
+	}
+
+	return Fit(records)
+}
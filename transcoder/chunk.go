@@ -0,0 +1,444 @@
+package transcoder
+
+import (
+	"fmt"
+	"github.com/Vilsol/transcoder-go/models"
+	"github.com/Vilsol/transcoder-go/notifications"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+type Chunk struct {
+	Start float64
+	End   float64
+}
+
+var showInfoTimeRegex = regexp.MustCompile(`Parsed_showinfo.*pts_time:\s*([0-9.]+)`)
+
+// PrepareChunks splits long files at scene cuts or keyframes so the video can be encoded by parallel ffmpeg processes
+func PrepareChunks(job *Job) error {
+	workers := viper.GetInt("chunks")
+	if workers < 2 {
+		return nil
+	}
+
+	video := job.Metadata.VideoStream()
+	if video == nil {
+		return nil
+	}
+
+	duration, _ := strconv.ParseFloat(job.Metadata.Format.Duration, 64)
+	minLength := viper.GetFloat64("chunk-length")
+	if duration < minLength*2 {
+		return nil
+	}
+
+	if containsFlag(strings.Split(viper.GetString("flags"), " "), "-map") {
+		log.Warningf("Not chunking %s: configured flags map streams themselves", job.FileName)
+		return nil
+	}
+
+	if job.Bitrate > 0 {
+		log.Warningf("Not chunking %s: two-pass encodes need the whole file", job.FileName)
+		return nil
+	}
+
+	for _, param := range job.X265Params {
+		if strings.HasPrefix(param, "dhdr10-info=") || strings.HasPrefix(param, "dolby-vision-rpu=") {
+			log.Warningf("Not chunking %s: dynamic HDR metadata cannot be split", job.FileName)
+			return nil
+		}
+	}
+
+	var cuts []float64
+	var err error
+
+	switch viper.GetString("chunk-split") {
+	case "scene":
+		cuts, err = findSceneCuts(job.FileName, video.Index, viper.GetFloat64("scene-threshold"))
+	case "keyframes":
+		cuts, err = findKeyframes(job.FileName, video.Index)
+
+		// Packet timestamps include the start time of the container, showinfo ones of scene detection start at zero
+		startTime, _ := strconv.ParseFloat(job.Metadata.Format.StartTime, 64)
+		for i := range cuts {
+			cuts[i] -= startTime
+		}
+	default:
+		return fmt.Errorf("unknown chunk split %s", viper.GetString("chunk-split"))
+	}
+
+	if err != nil {
+		return err
+	}
+
+	chunks := SplitChunks(cuts, duration, minLength)
+	if len(chunks) < 2 {
+		return nil
+	}
+
+	log.Infof("Encoding %s in %d chunks with %d processes", job.FileName, len(chunks), workers)
+
+	job.Chunks = chunks
+	job.Details = append(job.Details, fmt.Sprintf("Chunks: %d", len(chunks)))
+
+	return nil
+}
+
+// SplitChunks picks cut points that are at least minLength apart, the last chunk is never shorter than half of it
+func SplitChunks(cuts []float64, duration float64, minLength float64) []Chunk {
+	sort.Float64s(cuts)
+
+	chunks := make([]Chunk, 0)
+	start := 0.0
+
+	for _, cut := range cuts {
+		if cut-start < minLength || duration-cut < minLength/2 {
+			continue
+		}
+
+		chunks = append(chunks, Chunk{Start: start, End: cut})
+		start = cut
+	}
+
+	return append(chunks, Chunk{Start: start, End: duration})
+}
+
+func findKeyframes(fileName string, index int) ([]float64, error) {
+	params := []string{"-v", "quiet", "-select_streams", strconv.Itoa(index), "-show_entries", "packet=pts_time,flags", "-of", "csv=p=0", fileName}
+
+	log.Tracef("Executing ffprobe %s", strings.Join(params, " "))
+
+	out, err := exec.Command("ffprobe", params...).Output()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed reading keyframes")
+	}
+
+	return ParseKeyframes(string(out)), nil
+}
+
+// ParseKeyframes returns the timestamps of the keyframe packets in ffprobe csv output of pts_time and flags
+func ParseKeyframes(output string) []float64 {
+	keyframes := make([]float64, 0)
+	for _, line := range strings.Split(output, "\n") {
+		split := strings.Split(strings.TrimSpace(line), ",")
+		if len(split) < 2 || !strings.HasPrefix(split[1], "K") {
+			continue
+		}
+
+		if timestamp, err := strconv.ParseFloat(split[0], 64); err == nil {
+			keyframes = append(keyframes, timestamp)
+		}
+	}
+
+	return keyframes
+}
+
+func findSceneCuts(fileName string, index int, threshold float64) ([]float64, error) {
+	params := []string{
+		"-hide_banner", "-nostats",
+		"-i", fileName,
+		"-map", "0:" + strconv.Itoa(index),
+		"-vf", "select='gt(scene," + strconv.FormatFloat(threshold, 'f', -1, 64) + ")',showinfo",
+		"-an", "-sn", "-dn",
+		"-f", "null", "-",
+	}
+
+	out, err := runFFmpeg(params)
+	if err != nil {
+		return nil, errors.Wrapf(err, "scene detection failed: %s", lastLine(string(out)))
+	}
+
+	return ParseSceneCuts(string(out)), nil
+}
+
+// ParseSceneCuts returns the timestamps of the frames showinfo printed after the scene select filter
+func ParseSceneCuts(output string) []float64 {
+	cuts := make([]float64, 0)
+	for _, match := range showInfoTimeRegex.FindAllStringSubmatch(output, -1) {
+		if timestamp, err := strconv.ParseFloat(match[1], 64); err == nil {
+			cuts = append(cuts, timestamp)
+		}
+	}
+
+	return cuts
+}
+
+type chunkProgress struct {
+	lock      sync.Mutex
+	job       *Job
+	sizeLimit int64
+	lastLog   int64
+	reports   []*models.ProgressReport
+	aggregate *models.ProgressReport
+	stop      func()
+}
+
+// report aggregates the latest report of every chunk and runs the early exit checks on the total
+func (progress *chunkProgress) report(index int, report *models.ProgressReport) {
+	progress.lock.Lock()
+	defer progress.lock.Unlock()
+
+	progress.reports[index] = report
+
+	aggregate := &models.ProgressReport{Progress: "continue"}
+	weightedBitrate := 0.0
+	for _, r := range progress.reports {
+		if r == nil {
+			continue
+		}
+
+		aggregate.Frame += r.Frame
+		aggregate.TotalSize += r.TotalSize
+		weightedBitrate += r.Bitrate * float64(r.Frame)
+
+		if r.OutTimeUs > 0 {
+			// Chunks start at zero, their output times add up to the encoded duration
+			aggregate.OutTimeUs += r.OutTimeUs
+		}
+
+		if r.Progress != "end" {
+			aggregate.FPS += r.FPS
+			aggregate.Speed += r.Speed
+		}
+	}
+
+	if aggregate.Frame > 0 {
+		aggregate.Bitrate = weightedBitrate / float64(aggregate.Frame)
+	}
+
+	progress.aggregate = aggregate
+
+	if ShouldExitEarly(progress.job, progress.sizeLimit, aggregate) {
+		progress.stop()
+		return
+	}
+
+	notifications.NotifyProgressStatus(aggregate)
+
+	if time.Now().Unix()-progress.lastLog > int64(viper.GetInt("interval")) {
+		aggregate.Log(progress.job.FileName)
+		progress.lastLog = time.Now().Unix()
+	}
+}
+
+// TranscodeChunked encodes the video chunks in parallel and muxes them with the remaining streams of the source
+func TranscodeChunked(job *Job, skip chan bool) (bool, *models.ProgressReport, bool) {
+	notifications.NotifyStart(job.Metadata, job.OutputName, job.Details)
+
+	chunkFiles := make([]string, len(job.Chunks))
+	for i := range job.Chunks {
+		chunkFiles[i] = fmt.Sprintf("%s.chunk%03d.mkv", job.TempFileName, i)
+	}
+
+	listFile := job.TempFileName + ".chunks.txt"
+	job.TempFiles = append(job.TempFiles, chunkFiles...)
+	job.TempFiles = append(job.TempFiles, listFile)
+
+	stop := make(chan struct{})
+	stopped := false
+	skipping := false
+	var stopLock sync.Mutex
+	stopAll := func() {
+		stopLock.Lock()
+		defer stopLock.Unlock()
+
+		if !stopped {
+			stopped = true
+			close(stop)
+		}
+	}
+
+	progress := &chunkProgress{
+		job:       job,
+		sizeLimit: job.SizeLimit(),
+		reports:   make([]*models.ProgressReport, len(job.Chunks)),
+		stop:      stopAll,
+	}
+
+	terminate := make(chan os.Signal, 1)
+	signal.Notify(terminate, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(terminate)
+
+	finished := make(chan struct{})
+	defer close(finished)
+
+	go func() {
+		select {
+		case <-terminate:
+			stopAll()
+		case toSkip := <-skip:
+			stopLock.Lock()
+			skipping = toSkip
+			stopLock.Unlock()
+			stopAll()
+		case <-finished:
+		}
+	}()
+
+	queue := make(chan int, len(job.Chunks))
+	for i := range job.Chunks {
+		queue <- i
+	}
+	close(queue)
+
+	var failure error
+	var failureLock sync.Mutex
+
+	workers := viper.GetInt("chunks")
+	if workers > len(job.Chunks) {
+		workers = len(job.Chunks)
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for i := range queue {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+
+				err := encodeChunk(job, i, chunkFiles[i], stop, progress)
+				if err != nil {
+					failureLock.Lock()
+					if failure == nil {
+						failure = err
+					}
+					failureLock.Unlock()
+
+					stopAll()
+					return
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	stopLock.Lock()
+	wasStopped := stopped
+	wasSkipped := skipping
+	stopLock.Unlock()
+
+	if failure != nil {
+		log.Errorf("ffmpeg: %s", failure)
+		return false, progress.aggregate, false
+	}
+
+	if wasStopped {
+		log.Warningf("ffmpeg killed")
+		return true, progress.aggregate, wasSkipped
+	}
+
+	list := ""
+	for _, chunkFile := range chunkFiles {
+		list += "file '" + strings.ReplaceAll(chunkFile, "'", `'\''`) + "'\n"
+	}
+
+	if err := ioutil.WriteFile(listFile, []byte(list), 0644); err != nil {
+		log.Errorf("Error writing file %s: %s", listFile, err)
+		return false, progress.aggregate, false
+	}
+
+	job.VideoSource = listFile
+	flags := BuildFlags(job)
+
+	log.Infof("Muxing %d chunks of %s", len(chunkFiles), job.FileName)
+	log.Tracef("Executing ffmpeg %s", strings.Join(flags, " "))
+
+	c := ffmpegCommand(flags)
+	flushStderr := attachStderr(c, nil)
+
+	err := c.Run()
+	flushStderr()
+
+	if err != nil {
+		log.Errorf("ffmpeg: %s", err)
+	}
+
+	return false, progress.aggregate, false
+}
+
+func encodeChunk(job *Job, index int, chunkFile string, stop chan struct{}, progress *chunkProgress) error {
+	chunk := job.Chunks[index]
+	video := job.Metadata.VideoStream()
+
+	flags := []string{"-y"}
+	flags = append(flags, ffmpegLogFlags()...)
+
+	flags = append(flags, "-ss", strconv.FormatFloat(chunk.Start, 'f', 6, 64), "-i", job.FileName)
+
+	if index < len(job.Chunks)-1 {
+		flags = append(flags, "-t", strconv.FormatFloat(chunk.End-chunk.Start, 'f', 6, 64))
+	}
+
+	flags = append(flags, "-map", "0:"+strconv.Itoa(video.Index), "-c", "copy")
+	flags = append(flags, videoFlags(job)...)
+	flags = append(flags, colorFlags(job)...)
+	flags = append(flags, "-an", "-sn", "-dn", "-f", "matroska", "-progress", "-", chunkFile)
+
+	log.Tracef("Executing ffmpeg %s", strings.Join(flags, " "))
+
+	c := ffmpegCommand(flags)
+	flushStderr := attachStderr(c, log.Fields{"chunk": index})
+	defer flushStderr()
+
+	pipe, err := c.StdoutPipe()
+	if err != nil {
+		return errors.Wrap(err, "failed hooking ffmpeg stdout")
+	}
+
+	if err := c.Start(); err != nil {
+		return errors.Wrap(err, "failed running ffmpeg")
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		select {
+		case <-stop:
+			if err := c.Process.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+				log.Errorf("Error killing process: %s", err)
+			}
+		case <-done:
+		}
+	}()
+
+	ReadProgress(pipe, func(report *models.ProgressReport) bool {
+		progress.report(index, report)
+		return true
+	})
+
+	err = c.Wait()
+
+	select {
+	case <-stop:
+		// Killed on purpose
+		return nil
+	default:
+	}
+
+	if err != nil {
+		return errors.Wrapf(err, "chunk %d", index)
+	}
+
+	return nil
+}
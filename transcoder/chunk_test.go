@@ -0,0 +1,92 @@
+package transcoder
+
+import (
+	"testing"
+)
+
+func TestSplitChunks(t *testing.T) {
+	tests := []struct {
+		name     string
+		cuts     []float64
+		duration float64
+		expected []Chunk
+	}{
+		{
+			name:     "cuts closer than the minimum length are skipped",
+			cuts:     []float64{30, 130, 200, 260, 400, 550},
+			duration: 600,
+			expected: []Chunk{{0, 130}, {130, 260}, {260, 400}, {400, 600}},
+		},
+		{
+			name:     "unsorted cuts",
+			cuts:     []float64{400, 130, 260},
+			duration: 600,
+			expected: []Chunk{{0, 130}, {130, 260}, {260, 400}, {400, 600}},
+		},
+		{
+			name:     "last chunk at least half the minimum length",
+			cuts:     []float64{130, 250},
+			duration: 300,
+			expected: []Chunk{{0, 130}, {130, 300}},
+		},
+		{
+			name:     "no cuts",
+			duration: 600,
+			expected: []Chunk{{0, 600}},
+		},
+		{
+			name:     "shorter than the minimum length",
+			cuts:     []float64{50},
+			duration: 100,
+			expected: []Chunk{{0, 100}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			chunks := SplitChunks(test.cuts, test.duration, 120)
+
+			if len(chunks) != len(test.expected) {
+				t.Fatalf("expected %v, got %v", test.expected, chunks)
+			}
+
+			for i := range chunks {
+				if chunks[i] != test.expected[i] {
+					t.Errorf("expected %v, got %v", test.expected, chunks)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestParseKeyframes(t *testing.T) {
+	output := "0.000000,K__\n0.041708,___\n2.002000,K_\nN/A,K_\n4.004000,__\n6.006000,K__\n"
+
+	assertTimestamps(t, []float64{0, 2.002, 6.006}, ParseKeyframes(output))
+}
+
+func TestParseSceneCuts(t *testing.T) {
+	output := `[Parsed_showinfo_1 @ 0x55e2c0] config in time_base: 1/24000, frame_rate: 24000/1001
+[Parsed_showinfo_1 @ 0x55e2c0] n:   0 pts:  90090 pts_time:3.75375 duration:   1001 duration_time:0.0417083 fmt:yuv420p
+[Parsed_showinfo_1 @ 0x55e2c0] n:   1 pts: 312312 pts_time:13.013  duration:   1001 duration_time:0.0417083 fmt:yuv420p
+frame=  418 fps=0.0 q=-0.0 Lsize=N/A time=00:00:17.43 bitrate=N/A speed=34.8x
+`
+
+	assertTimestamps(t, []float64{3.75375, 13.013}, ParseSceneCuts(output))
+}
+
+func assertTimestamps(t *testing.T, expected []float64, actual []float64) {
+	t.Helper()
+
+	if len(expected) != len(actual) {
+		t.Fatalf("expected %v, got %v", expected, actual)
+	}
+
+	for i := range expected {
+		if expected[i] != actual[i] {
+			t.Errorf("expected %v, got %v", expected, actual)
+			return
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package transcoder
+
+import (
+	"fmt"
+	"github.com/Vilsol/transcoder-go/models"
+	"github.com/spf13/viper"
+)
+
+type Container struct {
+	Name      string
+	Format    string
+	Extension string
+	Flags     []string
+
+	// Subtitle codecs the container can hold, nil means any
+	SubtitleCodecs []string
+	Attachments    bool
+	Data           bool
+}
+
+var containers = map[string]*Container{
+	"mkv": {
+		Name:        "mkv",
+		Format:      "matroska",
+		Extension:   ".mkv",
+		Attachments: true,
+	},
+	"mp4": {
+		Name:           "mp4",
+		Format:         "mp4",
+		Extension:      ".mp4",
+		Flags:          []string{"-movflags", "+faststart+use_metadata_tags"},
+		SubtitleCodecs: []string{"mov_text"},
+	},
+	"webm": {
+		Name:           "webm",
+		Format:         "webm",
+		Extension:      ".webm",
+		SubtitleCodecs: []string{"webvtt"},
+	},
+}
+
+func GetContainer(name string) (*Container, error) {
+	container, ok := containers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown container %s", name)
+	}
+
+	return container, nil
+}
+
+// OutputContainer returns the container selected by the active configuration
+func OutputContainer() *Container {
+	container, err := GetContainer(viper.GetString("container"))
+	if err != nil {
+		return containers["mkv"]
+	}
+
+	return container
+}
+
+func (container *Container) supportsSubtitle(codec string) bool {
+	if container.SubtitleCodecs == nil {
+		return codec != "mov_text"
+	}
+
+	for _, c := range container.SubtitleCodecs {
+		if c == codec {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Check returns an error if the stream cannot be written to the container after applying the action
+func (container *Container) Check(stream models.Stream, action StreamAction) error {
+	switch action {
+	case StreamKeep:
+		switch stream.CodecType {
+		case "data":
+			if !container.Data {
+				return fmt.Errorf("stream %d: data streams (%s) are not supported by %s, add a data=drop rule", stream.Index, stream.CodecName, container.Name)
+			}
+		case "attachment":
+			if !container.Attachments {
+				return fmt.Errorf("stream %d: attachments are not supported by %s, add an attachment=drop rule", stream.Index, container.Name)
+			}
+		case "subtitle":
+			if !container.supportsSubtitle(stream.CodecName) {
+				return fmt.Errorf("stream %d: %s subtitles are not supported by %s, add a subtitle:*:%s rule to convert, extract or drop them", stream.Index, stream.CodecName, container.Name, stream.CodecName)
+			}
+		}
+	case StreamSRT, StreamASS, StreamMovText, StreamWebVTT:
+		if bitmapSubtitleCodecs[stream.CodecName] {
+			return fmt.Errorf("stream %d: bitmap subtitles (%s) cannot be converted to %s", stream.Index, stream.CodecName, action)
+		}
+
+		if !container.supportsSubtitle(action.Codec()) {
+			return fmt.Errorf("stream %d: %s subtitles are not supported by %s", stream.Index, action, container.Name)
+		}
+	case StreamExtract:
+		if stream.CodecName == "dvd_subtitle" || stream.CodecName == "dvb_subtitle" {
+			return fmt.Errorf("stream %d: %s subtitles cannot be extracted to a sidecar file", stream.Index, stream.CodecName)
+		}
+	}
+
+	return nil
+}
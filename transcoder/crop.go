@@ -0,0 +1,174 @@
+package transcoder
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"regexp"
+	"strconv"
+)
+
+type Crop struct {
+	Width  int
+	Height int
+	X      int
+	Y      int
+}
+
+func (crop Crop) String() string {
+	return fmt.Sprintf("%d:%d:%d:%d", crop.Width, crop.Height, crop.X, crop.Y)
+}
+
+var cropDetectRegex = regexp.MustCompile(`crop=(-?\d+):(-?\d+):(-?\d+):(-?\d+)`)
+
+// PrepareCrop samples the source with cropdetect and adds a crop filter if it has black bars
+func PrepareCrop(job *Job) error {
+	if !viper.GetBool("crop") {
+		return nil
+	}
+
+	video := job.Metadata.VideoStream()
+	if video == nil || video.Width == 0 || video.Height == 0 {
+		return nil
+	}
+
+	duration, _ := strconv.ParseFloat(job.Metadata.Format.Duration, 64)
+	if duration <= 0 {
+		return errors.New("cannot detect crop without a known duration")
+	}
+
+	samples := viper.GetInt("crop-samples")
+	if samples < 1 {
+		samples = 1
+	}
+
+	detected := make([]Crop, 0, samples)
+	for i := 0; i < samples; i++ {
+		timestamp := duration * float64(i+1) / float64(samples+1)
+
+		crop, err := detectCrop(job.FileName, timestamp)
+		if err != nil {
+			return err
+		}
+
+		if crop != nil {
+			detected = append(detected, *crop)
+		}
+	}
+
+	crop := StableCrop(detected, video.Width, video.Height, viper.GetInt("crop-margin"))
+	if crop == nil {
+		log.Debugf("No crop detected for %s", job.FileName)
+		return nil
+	}
+
+	log.Infof("Detected crop for %s: %s (from %dx%d)", job.FileName, crop, video.Width, video.Height)
+
+	job.VideoFilters = append(job.VideoFilters, "crop="+crop.String())
+	job.Details = append(job.Details, "Crop: "+crop.String())
+
+	return nil
+}
+
+func detectCrop(fileName string, timestamp float64) (*Crop, error) {
+	params := []string{
+		"-hide_banner", "-nostats",
+		"-ss", strconv.FormatFloat(timestamp, 'f', 3, 64),
+		"-i", fileName,
+		"-map", "0:v:0",
+		"-frames:v", "30",
+		"-vf", "cropdetect=limit=0.094:round=2:reset=0",
+		"-f", "null", "-",
+	}
+
+	out, err := runFFmpeg(params)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cropdetect failed at %.2fs", timestamp)
+	}
+
+	return ParseCropDetect(string(out)), nil
+}
+
+// ParseCropDetect returns the last rectangle reported by cropdetect, nil if there is none
+func ParseCropDetect(output string) *Crop {
+	matches := cropDetectRegex.FindAllStringSubmatch(output, -1)
+	if len(matches) == 0 {
+		return nil
+	}
+
+	last := matches[len(matches)-1]
+
+	crop := Crop{}
+	crop.Width, _ = strconv.Atoi(last[1])
+	crop.Height, _ = strconv.Atoi(last[2])
+	crop.X, _ = strconv.Atoi(last[3])
+	crop.Y, _ = strconv.Atoi(last[4])
+
+	// Fully black frames produce negative sizes
+	if crop.Width <= 0 || crop.Height <= 0 {
+		return nil
+	}
+
+	return &crop
+}
+
+// StableCrop combines the detected rectangles into one that covers all of them, grown by the margin on every side.
+// Returns nil if nothing would be cropped.
+func StableCrop(detected []Crop, width int, height int, margin int) *Crop {
+	if len(detected) == 0 {
+		return nil
+	}
+
+	left, top := width, height
+	right, bottom := 0, 0
+
+	for _, crop := range detected {
+		left = minInt(left, crop.X)
+		top = minInt(top, crop.Y)
+		right = maxInt(right, crop.X+crop.Width)
+		bottom = maxInt(bottom, crop.Y+crop.Height)
+	}
+
+	left = maxInt(0, left-margin)
+	top = maxInt(0, top-margin)
+	right = minInt(width, right+margin)
+	bottom = minInt(height, bottom+margin)
+
+	// Encoders need even dimensions
+	left += left % 2
+	top += top % 2
+	right -= right % 2
+	bottom -= bottom % 2
+
+	if right-left <= 0 || bottom-top <= 0 {
+		return nil
+	}
+
+	if left == 0 && top == 0 && right >= width-width%2 && bottom >= height-height%2 {
+		return nil
+	}
+
+	return &Crop{
+		Width:  right - left,
+		Height: bottom - top,
+		X:      left,
+		Y:      top,
+	}
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+
+	return b
+}
+
+func maxInt(a int, b int) int {
+	if a > b {
+		return a
+	}
+
+	return b
+}
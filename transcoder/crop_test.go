@@ -0,0 +1,119 @@
+package transcoder
+
+import (
+	"testing"
+)
+
+func TestParseCropDetect(t *testing.T) {
+	tests := []struct {
+		name     string
+		output   string
+		expected *Crop
+	}{
+		{
+			name: "last rectangle wins",
+			output: `[Parsed_cropdetect_0 @ 0x55d4c8] x1:0 x2:1919 y1:142 y2:937 w:1920 h:784 x:0 y:148 pts:1001 t:0.041708 crop=1920:784:0:148
+[Parsed_cropdetect_0 @ 0x55d4c8] x1:0 x2:1919 y1:140 y2:939 w:1920 h:800 x:0 y:140 pts:2002 t:0.083417 crop=1920:800:0:140
+`,
+			expected: &Crop{Width: 1920, Height: 800, X: 0, Y: 140},
+		},
+		{
+			name:     "black frames",
+			output:   "[Parsed_cropdetect_0 @ 0x55d4c8] x1:1919 x2:0 y1:1079 y2:0 w:-1904 h:-1072 x:1912 y:1076 pts:1001 t:0.041708 crop=-1904:-1072:1912:1076\n",
+			expected: nil,
+		},
+		{
+			name:     "no cropdetect output",
+			output:   "Output #0, null, to 'pipe:':\n",
+			expected: nil,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assertCrop(t, test.expected, ParseCropDetect(test.output))
+		})
+	}
+}
+
+func TestStableCrop(t *testing.T) {
+	letterbox := []Crop{
+		{Width: 1920, Height: 800, X: 0, Y: 140},
+		{Width: 1920, Height: 804, X: 0, Y: 138},
+	}
+
+	tests := []struct {
+		name     string
+		detected []Crop
+		width    int
+		height   int
+		margin   int
+		expected *Crop
+	}{
+		{
+			name:     "covers every sample",
+			detected: letterbox,
+			width:    1920,
+			height:   1080,
+			expected: &Crop{Width: 1920, Height: 804, X: 0, Y: 138},
+		},
+		{
+			name:     "grown by the margin",
+			detected: letterbox,
+			width:    1920,
+			height:   1080,
+			margin:   4,
+			expected: &Crop{Width: 1920, Height: 812, X: 0, Y: 134},
+		},
+		{
+			name:     "margin clamped to the frame",
+			detected: []Crop{{Width: 1916, Height: 1076, X: 2, Y: 2}},
+			width:    1920,
+			height:   1080,
+			margin:   8,
+			expected: nil,
+		},
+		{
+			name:     "odd edges rounded inwards",
+			detected: []Crop{{Width: 1918, Height: 1078, X: 1, Y: 1}},
+			width:    1920,
+			height:   1080,
+			expected: &Crop{Width: 1916, Height: 1076, X: 2, Y: 2},
+		},
+		{
+			name:     "full frame of an odd height",
+			detected: []Crop{{Width: 1920, Height: 1080, X: 0, Y: 0}},
+			width:    1920,
+			height:   1081,
+			expected: nil,
+		},
+		{
+			name:     "nothing detected",
+			width:    1920,
+			height:   1080,
+			expected: nil,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assertCrop(t, test.expected, StableCrop(test.detected, test.width, test.height, test.margin))
+		})
+	}
+}
+
+func assertCrop(t *testing.T, expected *Crop, actual *Crop) {
+	t.Helper()
+
+	if expected == nil || actual == nil {
+		if expected != actual {
+			t.Errorf("expected %v, got %v", expected, actual)
+		}
+
+		return
+	}
+
+	if *expected != *actual {
+		t.Errorf("expected %s, got %s", expected, actual)
+	}
+}
@@ -0,0 +1,168 @@
+package transcoder
+
+import (
+	"fmt"
+	"github.com/Vilsol/transcoder-go/cache"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"regexp"
+	"strconv"
+)
+
+type ScanType string
+
+const (
+	ScanProgressive  = ScanType("progressive")
+	ScanInterlaced   = ScanType("interlaced")
+	ScanTelecined    = ScanType("telecined")
+	ScanSoftTelecine = ScanType("soft telecine")
+)
+
+// IdetResult holds the frame counts reported by the idet filter
+type IdetResult struct {
+	TFF         int `json:"tff"`
+	BFF         int `json:"bff"`
+	Progressive int `json:"progressive"`
+	Repeated    int `json:"repeated"`
+	Total       int `json:"total"`
+}
+
+var idetMultiRegex = regexp.MustCompile(`Multi frame detection: TFF:\s*(\d+)\s+BFF:\s*(\d+)\s+Progressive:\s*(\d+)\s+Undetermined:\s*(\d+)`)
+var idetRepeatedRegex = regexp.MustCompile(`Repeated Fields: Neither:\s*(\d+)\s+Top:\s*(\d+)\s+Bottom:\s*(\d+)`)
+
+// PrepareDeinterlace classifies the source with idet and adds a deinterlacing or inverse telecine filter
+func PrepareDeinterlace(job *Job) error {
+	mode := viper.GetString("deinterlace")
+	if mode == "off" {
+		return nil
+	}
+
+	video := job.Metadata.VideoStream()
+	if video == nil {
+		return nil
+	}
+
+	scanType := ScanInterlaced
+	if mode == "auto" {
+		var err error
+		scanType, err = detectScanType(job)
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Infof("Scan type of %s: %s (field order %s)", job.FileName, scanType, video.FieldOrder)
+
+	switch scanType {
+	case ScanInterlaced:
+		job.VideoFilters = append(job.VideoFilters, "bwdif")
+	case ScanTelecined:
+		job.VideoFilters = append(job.VideoFilters, "fieldmatch", "decimate")
+	default:
+		return nil
+	}
+
+	job.Details = append(job.Details, "Scan type: "+string(scanType))
+
+	return nil
+}
+
+func detectScanType(job *Job) (ScanType, error) {
+	if job.Metadata.VideoStream().FieldOrder == "progressive" {
+		return ScanProgressive, nil
+	}
+
+	cacheKey, err := cache.FileKey(job.FileName, viper.GetString("deinterlace-frames"))
+	if err != nil {
+		return "", errors.Wrap(err, "failed building cache key")
+	}
+
+	store := cache.Open("idet")
+
+	var result IdetResult
+	if !store.Get(cacheKey, &result) {
+		result, err = runIdet(job)
+		if err != nil {
+			return "", err
+		}
+
+		if err := store.Set(cacheKey, result); err != nil {
+			log.Errorf("Error caching scan type of %s: %s", job.FileName, err)
+		}
+	}
+
+	log.Debugf("idet of %s: %+v", job.FileName, result)
+
+	return result.Classify(), nil
+}
+
+func runIdet(job *Job) (IdetResult, error) {
+	duration, _ := strconv.ParseFloat(job.Metadata.Format.Duration, 64)
+
+	params := []string{
+		"-hide_banner", "-nostats",
+		"-ss", strconv.FormatFloat(duration/3, 'f', 3, 64),
+		"-i", job.FileName,
+		"-map", "0:v:0",
+		"-frames:v", strconv.Itoa(viper.GetInt("deinterlace-frames")),
+		"-vf", "idet",
+		"-an", "-sn", "-dn",
+		"-f", "null", "-",
+	}
+
+	out, err := runFFmpeg(params)
+	if err != nil {
+		return IdetResult{}, errors.Wrapf(err, "idet failed: %s", lastLine(string(out)))
+	}
+
+	return ParseIdet(string(out))
+}
+
+// ParseIdet extracts the multi frame detection and repeated field counts from ffmpeg output
+func ParseIdet(output string) (IdetResult, error) {
+	multi := idetMultiRegex.FindAllStringSubmatch(output, -1)
+	if len(multi) == 0 {
+		return IdetResult{}, fmt.Errorf("no idet summary in ffmpeg output")
+	}
+
+	last := multi[len(multi)-1]
+
+	result := IdetResult{}
+	result.TFF, _ = strconv.Atoi(last[1])
+	result.BFF, _ = strconv.Atoi(last[2])
+	result.Progressive, _ = strconv.Atoi(last[3])
+	undetermined, _ := strconv.Atoi(last[4])
+	result.Total = result.TFF + result.BFF + result.Progressive + undetermined
+
+	if repeated := idetRepeatedRegex.FindAllStringSubmatch(output, -1); len(repeated) > 0 {
+		lastRepeated := repeated[len(repeated)-1]
+		top, _ := strconv.Atoi(lastRepeated[2])
+		bottom, _ := strconv.Atoi(lastRepeated[3])
+		result.Repeated = top + bottom
+	}
+
+	return result, nil
+}
+
+// Classify decides the scan type, 3:2 pulldown interlaces two out of every five frames
+func (result IdetResult) Classify() ScanType {
+	determined := result.TFF + result.BFF + result.Progressive
+	if determined == 0 {
+		return ScanProgressive
+	}
+
+	interlaced := float64(result.TFF+result.BFF) / float64(determined)
+	repeated := float64(result.Repeated) / float64(result.Total)
+
+	switch {
+	case interlaced >= 0.8:
+		return ScanInterlaced
+	case interlaced >= 0.2:
+		return ScanTelecined
+	case repeated >= 0.2:
+		return ScanSoftTelecine
+	default:
+		return ScanProgressive
+	}
+}
@@ -0,0 +1,70 @@
+package transcoder
+
+import (
+	"testing"
+)
+
+func TestParseIdet(t *testing.T) {
+	tests := []struct {
+		name     string
+		output   string
+		expected IdetResult
+		ok       bool
+	}{
+		{
+			name: "interlaced source",
+			output: `[Parsed_idet_0 @ 0x5637a1] Repeated Fields: Neither:   398 Top:     1 Bottom:     1
+[Parsed_idet_0 @ 0x5637a1] Single frame detection: TFF:   312 BFF:     0 Progressive:    60 Undetermined:    28
+[Parsed_idet_0 @ 0x5637a1] Multi frame detection: TFF:   388 BFF:     0 Progressive:     2 Undetermined:    10
+`,
+			expected: IdetResult{TFF: 388, Progressive: 2, Repeated: 2, Total: 400},
+			ok:       true,
+		},
+		{
+			name:     "without repeated fields",
+			output:   "[Parsed_idet_0 @ 0x5637a1] Multi frame detection: TFF:     0 BFF:   120 Progressive:    80 Undetermined:     0\n",
+			expected: IdetResult{BFF: 120, Progressive: 80, Total: 200},
+			ok:       true,
+		},
+		{
+			name:   "no summary",
+			output: "[mpeg2video @ 0x5637a1] ac-tex damaged at 12 20\n",
+			ok:     false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := ParseIdet(test.output)
+			if (err == nil) != test.ok {
+				t.Fatalf("expected ok %v, got error %v", test.ok, err)
+			}
+
+			if result != test.expected {
+				t.Errorf("expected %+v, got %+v", test.expected, result)
+			}
+		})
+	}
+}
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name     string
+		result   IdetResult
+		expected ScanType
+	}{
+		{name: "interlaced", result: IdetResult{TFF: 388, Progressive: 2, Total: 400}, expected: ScanInterlaced},
+		{name: "telecined", result: IdetResult{TFF: 160, Progressive: 240, Total: 400}, expected: ScanTelecined},
+		{name: "soft telecine", result: IdetResult{Progressive: 400, Repeated: 160, Total: 400}, expected: ScanSoftTelecine},
+		{name: "progressive with noise", result: IdetResult{TFF: 5, Progressive: 395, Repeated: 2, Total: 400}, expected: ScanProgressive},
+		{name: "undetermined", result: IdetResult{Total: 400}, expected: ScanProgressive},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := test.result.Classify(); actual != test.expected {
+				t.Errorf("expected %s, got %s", test.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,239 @@
+package transcoder
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/Vilsol/transcoder-go/joblog"
+	"github.com/Vilsol/transcoder-go/models"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"math"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+var ErrDolbyVisionSkipped = errors.New("dolby vision source skipped")
+
+// PrepareHDR carries HDR10, HDR10+ and Dolby Vision metadata of the source over to x265
+func PrepareHDR(job *Job) error {
+	if !viper.GetBool("hdr-passthrough") || !usesX265() || job.Tonemapped {
+		return nil
+	}
+
+	video := job.Metadata.VideoStream()
+	if video == nil {
+		return nil
+	}
+
+	sideData := video.SideDataList
+	dovi := video.SideData(models.SideDataDOVIConfiguration)
+
+	if !IsHDR(video) && dovi == nil {
+		return nil
+	}
+
+	frameSideData, err := ReadFrameSideData(job.FileName)
+	if err != nil {
+		log.Warningf("Failed reading frame side data of %s: %s", job.FileName, err)
+	}
+
+	sideData = append(sideData, frameSideData...)
+
+	params := []string{"repeat-headers=1"}
+	if video.ColorTransfer != nil && *video.ColorTransfer == "smpte2084" {
+		params = append(params, "hdr10=1", "hdr10-opt=1")
+	}
+
+	if dovi != nil {
+		doviParams, err := prepareDolbyVision(job, video, dovi)
+		if err != nil {
+			return err
+		}
+
+		params = append(params, doviParams...)
+	}
+
+	if masteringDisplay := findSideData(sideData, models.SideDataMasteringDisplay); masteringDisplay != nil {
+		params = append(params, "master-display="+MasterDisplay(masteringDisplay))
+	}
+
+	if contentLight := findSideData(sideData, models.SideDataContentLightLevel); contentLight != nil {
+		params = append(params, fmt.Sprintf("max-cll=%d,%d", contentLight.MaxContent, contentLight.MaxAverage))
+	}
+
+	if findSideData(sideData, models.SideDataHDR10Plus) != nil {
+		metadataFile := job.TempFileName + ".hdr10plus.json"
+		job.TempFiles = append(job.TempFiles, metadataFile)
+
+		if err := extractVideoMetadata(job.FileName, video, "hdr10plus_tool", "extract", "-", "-o", metadataFile); err != nil {
+			log.Warningf("HDR10+ metadata of %s will be lost: %s", job.FileName, err)
+		} else {
+			params = append(params, "dhdr10-info="+metadataFile)
+		}
+	}
+
+	log.Infof("Passing HDR metadata through: %s", strings.Join(params, ":"))
+
+	job.X265Params = append(job.X265Params, params...)
+
+	return nil
+}
+
+func prepareDolbyVision(job *Job, video *models.Stream, dovi *models.SideData) ([]string, error) {
+	switch viper.GetString("dolby-vision") {
+	case "skip":
+		return nil, ErrDolbyVisionSkipped
+	case "strip":
+		log.Warningf("Dropping Dolby Vision profile %d metadata of %s", dovi.DVProfile, job.FileName)
+		return nil, nil
+	}
+
+	profile := ""
+	mode := ""
+
+	switch {
+	case dovi.DVProfile == 5:
+		profile = "5"
+	case dovi.DVProfile == 7:
+		// Dual layer profile 7 is converted to single layer profile 8.1
+		profile = "8.1"
+		mode = "2"
+	case dovi.DVProfile == 8 && dovi.DVBLSignalCompatibilityID == 1:
+		profile = "8.1"
+	case dovi.DVProfile == 8 && dovi.DVBLSignalCompatibilityID == 2:
+		profile = "8.2"
+	case dovi.DVProfile == 8 && dovi.DVBLSignalCompatibilityID == 4:
+		profile = "8.4"
+	default:
+		return nil, fmt.Errorf("dolby vision profile %d with compatibility id %d cannot be carried by x265", dovi.DVProfile, dovi.DVBLSignalCompatibilityID)
+	}
+
+	configured := strings.Join(configuredX265Params(), ":")
+	if !strings.Contains(configured, "vbv-maxrate") || !strings.Contains(configured, "vbv-bufsize") {
+		return nil, errors.New("dolby vision passthrough requires vbv-maxrate and vbv-bufsize in -x265-params")
+	}
+
+	rpuFile := job.TempFileName + ".rpu.bin"
+	job.TempFiles = append(job.TempFiles, rpuFile)
+
+	args := make([]string, 0)
+	if mode != "" {
+		args = append(args, "-m", mode)
+	}
+	args = append(args, "extract-rpu", "-", "-o", rpuFile)
+
+	if err := extractVideoMetadata(job.FileName, video, "dovi_tool", args...); err != nil {
+		return nil, errors.Wrap(err, "failed extracting dolby vision rpu")
+	}
+
+	return []string{"dolby-vision-profile=" + profile, "dolby-vision-rpu=" + rpuFile}, nil
+}
+
+// IsHDR reports whether the stream uses a PQ or HLG transfer
+func IsHDR(stream *models.Stream) bool {
+	if stream.ColorTransfer == nil {
+		return false
+	}
+
+	return *stream.ColorTransfer == "smpte2084" || *stream.ColorTransfer == "arib-std-b67"
+}
+
+// MasterDisplay formats mastering display metadata the way x265 expects it, in 0.00002 chromaticity and 0.0001 cd/m2 units
+func MasterDisplay(data *models.SideData) string {
+	chroma := func(value string) int64 {
+		return int64(math.Round(parseRational(value) * 50000))
+	}
+
+	luminance := func(value string) int64 {
+		return int64(math.Round(parseRational(value) * 10000))
+	}
+
+	return fmt.Sprintf("G(%d,%d)B(%d,%d)R(%d,%d)WP(%d,%d)L(%d,%d)",
+		chroma(data.GreenX), chroma(data.GreenY),
+		chroma(data.BlueX), chroma(data.BlueY),
+		chroma(data.RedX), chroma(data.RedY),
+		chroma(data.WhitePointX), chroma(data.WhitePointY),
+		luminance(data.MaxLuminance), luminance(data.MinLuminance),
+	)
+}
+
+func parseRational(value string) float64 {
+	split := strings.Split(value, "/")
+
+	a, _ := strconv.ParseFloat(split[0], 64)
+	if len(split) == 1 {
+		return a
+	}
+
+	b, _ := strconv.ParseFloat(split[1], 64)
+	if b == 0 {
+		return 0
+	}
+
+	return a / b
+}
+
+func findSideData(sideData []models.SideData, sideDataType string) *models.SideData {
+	for i := range sideData {
+		if sideData[i].SideDataType == sideDataType {
+			return &sideData[i]
+		}
+	}
+
+	return nil
+}
+
+// extractVideoMetadata pipes the raw HEVC bitstream of the video stream into an external metadata tool
+func extractVideoMetadata(fileName string, video *models.Stream, tool string, args ...string) error {
+	if video.CodecName != "hevc" {
+		return fmt.Errorf("%s only supports hevc sources, got %s", tool, video.CodecName)
+	}
+
+	if _, err := exec.LookPath(tool); err != nil {
+		return errors.Wrapf(err, "%s is required", tool)
+	}
+
+	ffmpegParams := append(ffmpegLogFlags(), "-i", fileName, "-map", "0:"+strconv.Itoa(video.Index), "-c:v", "copy", "-bsf:v", "hevc_mp4toannexb", "-f", "hevc", "-")
+
+	log.Tracef("Executing ffmpeg %s | %s %s", strings.Join(ffmpegParams, " "), tool, strings.Join(args, " "))
+
+	joblog.Command("ffmpeg", ffmpegParams)
+	joblog.Command(tool, args)
+
+	ffmpeg := exec.Command("ffmpeg", ffmpegParams...)
+	extractor := exec.Command(tool, args...)
+
+	closeStderr := attachStderr(ffmpeg, nil)
+	defer closeStderr()
+
+	pipe, err := ffmpeg.StdoutPipe()
+	if err != nil {
+		return errors.Wrap(err, "failed hooking ffmpeg stdout")
+	}
+
+	var extractorOutput bytes.Buffer
+	extractor.Stdin = pipe
+	extractor.Stdout = &extractorOutput
+	extractor.Stderr = &extractorOutput
+
+	if err := ffmpeg.Start(); err != nil {
+		return errors.Wrap(err, "failed running ffmpeg")
+	}
+
+	err = extractor.Run()
+	copyToJobLog(extractorOutput.Bytes(), log.Fields{"tool": tool})
+
+	if err != nil {
+		_ = ffmpeg.Process.Kill()
+		_ = ffmpeg.Wait()
+		return errors.Wrapf(err, "%s: %s", tool, strings.TrimSpace(extractorOutput.String()))
+	}
+
+	if err := ffmpeg.Wait(); err != nil {
+		return errors.Wrap(err, "ffmpeg exited")
+	}
+
+	return nil
+}
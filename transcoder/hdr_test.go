@@ -0,0 +1,86 @@
+package transcoder
+
+import (
+	"encoding/json"
+	"github.com/Vilsol/transcoder-go/models"
+	"testing"
+)
+
+func TestMasterDisplay(t *testing.T) {
+	tests := []struct {
+		name     string
+		ffprobe  string
+		expected string
+	}{
+		{
+			name: "display p3 at 1000 nits",
+			ffprobe: `{
+				"side_data_type": "Mastering display metadata",
+				"red_x": "34000/50000", "red_y": "16000/50000",
+				"green_x": "13250/50000", "green_y": "34500/50000",
+				"blue_x": "7500/50000", "blue_y": "3000/50000",
+				"white_point_x": "15635/50000", "white_point_y": "16450/50000",
+				"min_luminance": "50/10000", "max_luminance": "10000000/10000"
+			}`,
+			expected: "G(13250,34500)B(7500,3000)R(34000,16000)WP(15635,16450)L(10000000,50)",
+		},
+		{
+			name: "bt.2020 with other denominators",
+			ffprobe: `{
+				"side_data_type": "Mastering display metadata",
+				"red_x": "708/1000", "red_y": "292/1000",
+				"green_x": "170/1000", "green_y": "797/1000",
+				"blue_x": "131/1000", "blue_y": "46/1000",
+				"white_point_x": "3127/10000", "white_point_y": "329/1000",
+				"min_luminance": "1/10000", "max_luminance": "4000/1"
+			}`,
+			expected: "G(8500,39850)B(6550,2300)R(35400,14600)WP(15635,16450)L(40000000,1)",
+		},
+		{
+			name: "missing values and zero denominators",
+			ffprobe: `{
+				"side_data_type": "Mastering display metadata",
+				"red_x": "0.708", "red_y": "292/0"
+			}`,
+			expected: "G(0,0)B(0,0)R(35400,0)WP(0,0)L(0,0)",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var data models.SideData
+			if err := json.Unmarshal([]byte(test.ffprobe), &data); err != nil {
+				t.Fatal(err)
+			}
+
+			if actual := MasterDisplay(&data); actual != test.expected {
+				t.Errorf("expected %s, got %s", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestIsHDR(t *testing.T) {
+	tests := []struct {
+		transfer string
+		expected bool
+	}{
+		{transfer: "smpte2084", expected: true},
+		{transfer: "arib-std-b67", expected: true},
+		{transfer: "bt709", expected: false},
+		{transfer: "", expected: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.transfer, func(t *testing.T) {
+			stream := &models.Stream{}
+			if test.transfer != "" {
+				stream.ColorTransfer = &test.transfer
+			}
+
+			if actual := IsHDR(stream); actual != test.expected {
+				t.Errorf("expected %v, got %v", test.expected, actual)
+			}
+		})
+	}
+}
@@ -2,15 +2,69 @@ package transcoder
 
 import (
 	"encoding/json"
+	"github.com/Vilsol/transcoder-go/cache"
 	"github.com/Vilsol/transcoder-go/models"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
 	"io/ioutil"
 	"os/exec"
+	"path/filepath"
+	"strconv"
 	"strings"
 )
 
+type cachedMetadata struct {
+	Key      string               `json:"key"`
+	Metadata *models.FileMetadata `json:"metadata"`
+}
+
+// ReadFileMetadata probes the file, reusing the cached result while its size, mtime and optionally inode are unchanged
 func ReadFileMetadata(file string) (*models.FileMetadata, error) {
+	if viper.GetBool("no-cache") {
+		return ProbeFile(file)
+	}
+
+	absPath, err := filepath.Abs(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed resolving path")
+	}
+
+	extra := make([]string, 0)
+	if viper.GetBool("cache-inode") {
+		if inode, ok := cache.Inode(absPath); ok {
+			extra = append(extra, strconv.FormatUint(inode, 10))
+		}
+	}
+
+	key, err := cache.FileKey(absPath, extra...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed reading file")
+	}
+
+	store := cache.Open("metadata")
+
+	var cached cachedMetadata
+	if store.Get(absPath, &cached) && cached.Key == key && cached.Metadata != nil {
+		log.Tracef("Using cached metadata of %s", file)
+		return cached.Metadata, nil
+	}
+
+	metadata, err := ProbeFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	// Entries of changed files are replaced, the store is written by cache.SaveAll
+	if err := store.Put(absPath, cachedMetadata{Key: key, Metadata: metadata}); err != nil {
+		log.Errorf("Error caching metadata of %s: %s", file, err)
+	}
+
+	return metadata, nil
+}
+
+// ProbeFile runs ffprobe on the file, retrying up to three times
+func ProbeFile(file string) (*models.FileMetadata, error) {
 	params := []string{"-v", "quiet", "-print_format", "json", "-show_format", "-show_streams", file}
 
 	log.Tracef("Executing ffprobe %s", strings.Join(params, " "))
@@ -55,3 +109,33 @@ func ReadFileMetadata(file string) (*models.FileMetadata, error) {
 
 	return nil, outerErr
 }
+
+// ReadFrameSideData returns the side data of the first video frame, which is where HDR metadata
+// ends up for containers that do not carry it at the stream level
+func ReadFrameSideData(file string) ([]models.SideData, error) {
+	params := []string{"-v", "quiet", "-print_format", "json", "-select_streams", "v:0", "-read_intervals", "%+#1", "-show_entries", "frame=side_data_list", file}
+
+	log.Tracef("Executing ffprobe %s", strings.Join(params, " "))
+
+	stdoutData, err := exec.Command("ffprobe", params...).Output()
+	if err != nil {
+		return nil, errors.Wrap(err, "ffprobe exited")
+	}
+
+	var frames struct {
+		Frames []struct {
+			SideDataList []models.SideData `json:"side_data_list"`
+		} `json:"frames"`
+	}
+
+	err = json.Unmarshal(stdoutData, &frames)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed parsing ffprobe output")
+	}
+
+	if len(frames.Frames) == 0 {
+		return nil, nil
+	}
+
+	return frames.Frames[0].SideDataList, nil
+}
@@ -0,0 +1,80 @@
+package transcoder
+
+import (
+	"github.com/Vilsol/transcoder-go/models"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"os"
+)
+
+// Job holds everything decided about a file before ffmpeg is started
+type Job struct {
+	FileName     string
+	TempFileName string
+
+	// Path the finished output is written to, the processed sidecar is named after it
+	OutputName string
+
+	Metadata *models.FileMetadata
+	Streams  []StreamPlan
+
+	// CRF picked by the target quality search, 0 keeps the configured CRF
+	CRF int
+
+	// Video bitrate of a two-pass encode, replaces the configured CRF
+	Bitrate int64
+
+	// Expected output size of a two-pass encode
+	TargetSize int64
+
+	// Extra flags appended after the configured flags
+	ExtraFlags []string
+
+	// Extra x265 parameters merged into the configured -x265-params
+	X265Params []string
+
+	// Extra video filters appended to the configured filter chain
+	VideoFilters []string
+
+	// Human readable decisions made while preparing the job, shown in notifications
+	Details []string
+
+	// Whether the video is tonemapped to SDR, which replaces the color flags of the source
+	Tonemapped bool
+
+	// Time ranges of the video encoded by parallel processes, empty for a single process
+	Chunks []Chunk
+
+	// Concat list of the encoded chunks, replaces the video of the source when set
+	VideoSource string
+
+	// Decides whether to give up on the encode early, nil falls back to extrapolation
+	Predictor EarlyExitPredictor
+
+	// Files created while preparing the job that are removed once it finishes
+	TempFiles []string
+}
+
+// SizeLimit is the output size above which finishing the transcode is pointless
+func (job *Job) SizeLimit() int64 {
+	limit := job.Metadata.Format.SizeInt()
+
+	if job.TargetSize > 0 {
+		budget := int64(float64(job.TargetSize) * (1 + viper.GetFloat64("target-tolerance")))
+		if budget < limit {
+			limit = budget
+		}
+	}
+
+	return limit
+}
+
+func (job *Job) Cleanup() {
+	for _, file := range job.TempFiles {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			log.Errorf("Error deleting file %s: %s", file, err)
+		}
+	}
+
+	job.TempFiles = nil
+}
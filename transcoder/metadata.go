@@ -0,0 +1,96 @@
+package transcoder
+
+import (
+	"github.com/Vilsol/transcoder-go/models"
+	"github.com/spf13/viper"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MetadataFlags carries over global tags, chapters and per-stream titles, languages and dispositions.
+// Per-stream flags are only added when the stream mapping is known.
+func MetadataFlags(job *Job, mapped bool) []string {
+	flags := make([]string, 0)
+
+	if viper.GetBool("preserve-metadata") {
+		flags = append(flags, "-map_metadata", "0", "-map_chapters", "0")
+
+		if mapped {
+			for outputIndex, stream := range OutputStreams(job.Streams) {
+				specifier := strconv.Itoa(outputIndex)
+
+				flags = append(flags, "-map_metadata:s:"+specifier, "0:s:"+strconv.Itoa(stream.Stream.Index))
+
+				if language, ok := stream.Stream.Tags["language"]; ok {
+					flags = append(flags, "-metadata:s:"+specifier, "language="+language)
+				}
+
+				if title, ok := stream.Stream.Tags["title"]; ok {
+					flags = append(flags, "-metadata:s:"+specifier, "title="+title)
+				}
+
+				if stream.Stream.CodecType != "attachment" && stream.Stream.Disposition != nil {
+					flags = append(flags, "-disposition:"+specifier, Disposition(stream.Stream.Disposition))
+				}
+			}
+		}
+	}
+
+	for _, tag := range viper.GetStringSlice("metadata-tags") {
+		flags = append(flags, "-metadata", tag)
+	}
+
+	if stamp := viper.GetString("stamp-tag"); stamp != "" {
+		flags = append(flags, "-metadata", stamp+"="+StampValue(job))
+	}
+
+	return flags
+}
+
+// Disposition converts ffprobe dispositions to the value accepted by ffmpeg's -disposition
+func Disposition(disposition map[string]int) string {
+	set := make([]string, 0)
+
+	for name, value := range disposition {
+		if value != 0 {
+			set = append(set, name)
+		}
+	}
+
+	if len(set) == 0 {
+		return "0"
+	}
+
+	sort.Strings(set)
+
+	return strings.Join(set, "+")
+}
+
+// StampValue describes the profile and flags used for a transcode
+func StampValue(job *Job) string {
+	profile := viper.GetString("profile")
+	if profile == "" {
+		profile = "default"
+	}
+
+	value := "profile=" + profile + "; container=" + OutputContainer().Name + "; flags=" + viper.GetString("flags")
+
+	if job.CRF > 0 {
+		value += "; crf=" + strconv.Itoa(job.CRF)
+	}
+
+	return value
+}
+
+// IsStamped reports whether the file was produced by this tool, based on the stamp tag in its container
+func IsStamped(metadata *models.FileMetadata) bool {
+	stamp := viper.GetString("stamp-tag")
+	if stamp == "" {
+		return false
+	}
+
+	value, ok := metadata.Format.Tag(stamp)
+
+	return ok && strings.HasPrefix(value, "profile=")
+}
@@ -0,0 +1,121 @@
+package transcoder
+
+import (
+	"encoding/json"
+	"github.com/Vilsol/transcoder-go/models"
+	"github.com/spf13/viper"
+	"testing"
+)
+
+func TestIsStamped(t *testing.T) {
+	viper.Set("stamp-tag", "TRANSCODER_GO")
+	defer viper.Set("stamp-tag", nil)
+
+	tests := []struct {
+		name     string
+		ffprobe  string
+		expected bool
+	}{
+		{
+			name: "matroska output",
+			ffprobe: `{
+				"streams": [{"index": 0, "codec_name": "hevc", "codec_type": "video"}],
+				"format": {
+					"filename": "/media/movie.mkv",
+					"format_name": "matroska,webm",
+					"duration": "5400.000000",
+					"size": "1073741824",
+					"bit_rate": "1590728",
+					"tags": {
+						"ENCODER": "Lavf59.27.100",
+						"TRANSCODER_GO": "profile=default; container=mkv; flags=-c:v libx265 -preset ultrafast"
+					}
+				}
+			}`,
+			expected: true,
+		},
+		{
+			name: "mp4 output with lowercase tag",
+			ffprobe: `{
+				"streams": [],
+				"format": {
+					"filename": "/media/movie.mp4",
+					"format_name": "mov,mp4,m4a,3gp,3g2,mj2",
+					"tags": {
+						"major_brand": "isom",
+						"transcoder_go": "profile=mobile; container=mp4; flags=-c:v libx265"
+					}
+				}
+			}`,
+			expected: true,
+		},
+		{
+			name: "untouched source",
+			ffprobe: `{
+				"streams": [{"index": 0, "codec_name": "h264", "codec_type": "video"}],
+				"format": {
+					"filename": "/media/movie.mkv",
+					"format_name": "matroska,webm",
+					"tags": {
+						"ENCODER": "libebml v1.3.10 + libmatroska v1.5.2"
+					}
+				}
+			}`,
+			expected: false,
+		},
+		{
+			name: "unrelated value in tag",
+			ffprobe: `{
+				"streams": [],
+				"format": {
+					"filename": "/media/movie.mkv",
+					"tags": {
+						"TRANSCODER_GO": "yes"
+					}
+				}
+			}`,
+			expected: false,
+		},
+		{
+			name: "no tags",
+			ffprobe: `{
+				"streams": [],
+				"format": {
+					"filename": "/media/movie.flv",
+					"format_name": "flv"
+				}
+			}`,
+			expected: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var metadata models.FileMetadata
+			if err := json.Unmarshal([]byte(test.ffprobe), &metadata); err != nil {
+				t.Fatal(err)
+			}
+
+			if stamped := IsStamped(&metadata); stamped != test.expected {
+				t.Errorf("expected %t, got %t", test.expected, stamped)
+			}
+		})
+	}
+}
+
+func TestIsStampedDisabled(t *testing.T) {
+	viper.Set("stamp-tag", "")
+	defer viper.Set("stamp-tag", nil)
+
+	metadata := models.FileMetadata{
+		Format: models.Format{
+			Tags: map[string]string{
+				"TRANSCODER_GO": "profile=default; container=mkv; flags=",
+			},
+		},
+	}
+
+	if IsStamped(&metadata) {
+		t.Error("expected stamp detection to be disabled")
+	}
+}
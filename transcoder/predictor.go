@@ -0,0 +1,187 @@
+package transcoder
+
+import (
+	"fmt"
+	"github.com/Vilsol/transcoder-go/models"
+	"github.com/Vilsol/transcoder-go/utils"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"os"
+	"strconv"
+)
+
+var ErrPredictedLarger = errors.New("output is predicted to be larger than the size limit")
+
+// EarlyExitPredictor estimates how confident we are that the output is going to end up too large to be kept
+type EarlyExitPredictor interface {
+	// Prepare runs once before the encode starts
+	Prepare(job *Job) error
+
+	// SkipConfidence is compared against the configured skip-confidence after every progress report
+	SkipConfidence(job *Job, sizeLimit int64, report *models.ProgressReport) float64
+}
+
+// ExtrapolationPredictor linearly extrapolates the current output size, only once a quarter of the file is done
+type ExtrapolationPredictor struct{}
+
+func (ExtrapolationPredictor) Prepare(*Job) error {
+	return nil
+}
+
+func (ExtrapolationPredictor) SkipConfidence(job *Job, sizeLimit int64, report *models.ProgressReport) float64 {
+	return utils.SkipConfidenceAt(sizeLimit, report.TotalSize, job.Metadata.Completion(report))
+}
+
+// SamplePredictor encodes short samples spread across the file up front and predicts the size of the remaining part from them
+type SamplePredictor struct {
+	// Predicted size of the whole output
+	PredictedSize int64
+}
+
+func (predictor *SamplePredictor) Prepare(job *Job) error {
+	if job.Bitrate > 0 {
+		// Samples would be encoded as second pass against the stats of the whole file,
+		// the two-pass output is bounded by the target size anyway
+		log.Debugf("Not sampling %s, encoding to a target bitrate", job.FileName)
+		return nil
+	}
+
+	duration, _ := strconv.ParseFloat(job.Metadata.Format.Duration, 64)
+	if duration <= 0 {
+		return errors.New("cannot sample without a known duration")
+	}
+
+	video := job.Metadata.VideoStream()
+	if video == nil {
+		return nil
+	}
+
+	samples := viper.GetInt("predictor-samples")
+	if samples < 1 {
+		samples = 1
+	}
+
+	length := viper.GetFloat64("predictor-sample-length")
+	if length*float64(samples) > duration {
+		length = duration / float64(samples)
+	}
+
+	sampleFile := job.TempFileName + ".predict.mkv"
+	defer os.Remove(sampleFile)
+
+	total := int64(0)
+	for i := 0; i < samples; i++ {
+		start := duration * float64(i) / float64(samples)
+
+		params := append([]string{"-y"}, ffmpegLogFlags()...)
+		params = append(params,
+			"-ss", strconv.FormatFloat(start, 'f', 3, 64),
+			"-t", strconv.FormatFloat(length, 'f', 3, 64),
+			"-i", job.FileName,
+			"-map", "0:"+strconv.Itoa(video.Index), "-c", "copy",
+		)
+		params = append(params, videoFlags(job)...)
+		params = append(params, colorFlags(job)...)
+		params = append(params, "-an", "-sn", "-dn", "-f", "matroska", sampleFile)
+
+		if out, err := runFFmpeg(params); err != nil {
+			return errors.Wrapf(err, "failed encoding size sample: %s", lastLine(string(out)))
+		}
+
+		stat, err := os.Stat(sampleFile)
+		if err != nil {
+			return errors.Wrap(err, "failed reading size sample")
+		}
+
+		total += stat.Size()
+	}
+
+	videoSize := float64(total) / (length * float64(samples)) * duration
+	audioSize := float64(audioBitrate(job)) / 8 * duration
+
+	predictor.PredictedSize = int64(videoSize + audioSize)
+
+	log.Infof("Predicted output size of %s: %s", job.FileName, utils.BytesHumanReadable(predictor.PredictedSize))
+
+	job.Details = append(job.Details, "Predicted size: "+utils.BytesHumanReadable(predictor.PredictedSize))
+
+	return nil
+}
+
+func (predictor *SamplePredictor) SkipConfidence(job *Job, sizeLimit int64, report *models.ProgressReport) float64 {
+	if predictor.PredictedSize <= 0 {
+		return ExtrapolationPredictor{}.SkipConfidence(job, sizeLimit, report)
+	}
+
+	completion := job.Metadata.Completion(report)
+	if completion > 100 {
+		completion = 100
+	}
+
+	// What has been written so far plus the predicted size of the rest
+	expected := float64(report.TotalSize) + (1-completion/100)*float64(predictor.PredictedSize)
+
+	// The samples count as a share of the part that is not encoded yet
+	effective := completion + (100-completion)*viper.GetFloat64("predictor-sample-weight")
+	if effective > 99 {
+		effective = 99
+	}
+
+	return utils.ExpectedSkipConfidence(float64(sizeLimit), expected, effective)
+}
+
+// NewEarlyExitPredictor returns the predictor with the given name
+func NewEarlyExitPredictor(name string) (EarlyExitPredictor, error) {
+	switch name {
+	case "extrapolate":
+		return ExtrapolationPredictor{}, nil
+	case "sample":
+		return &SamplePredictor{}, nil
+	}
+
+	return nil, fmt.Errorf("unknown early exit predictor %s", name)
+}
+
+// PrepareEarlyExit sets up the configured predictor and checks whether the output is already predicted to be too large
+func PrepareEarlyExit(job *Job) error {
+	if !viper.GetBool("early-exit") || !viper.GetBool("keep-old") {
+		return nil
+	}
+
+	predictor, err := NewEarlyExitPredictor(viper.GetString("early-exit-predictor"))
+	if err != nil {
+		return err
+	}
+
+	if err := predictor.Prepare(job); err != nil {
+		return err
+	}
+
+	job.Predictor = predictor
+
+	if confidence := SkipConfidence(job, job.SizeLimit(), &models.ProgressReport{}); confidence > viper.GetFloat64("skip-confidence") {
+		log.Infof("Skip confidence of %s before encoding: %.2f", job.FileName, confidence)
+		return ErrPredictedLarger
+	}
+
+	return nil
+}
+
+// PredictedSize returns the output size predicted by sampling, 0 if the job was not sampled
+func PredictedSize(job *Job) int64 {
+	if predictor, ok := job.Predictor.(*SamplePredictor); ok {
+		return predictor.PredictedSize
+	}
+
+	return 0
+}
+
+// SkipConfidence asks the predictor of the job, falling back to extrapolation
+func SkipConfidence(job *Job, sizeLimit int64, report *models.ProgressReport) float64 {
+	if job.Predictor == nil {
+		return ExtrapolationPredictor{}.SkipConfidence(job, sizeLimit, report)
+	}
+
+	return job.Predictor.SkipConfidence(job, sizeLimit, report)
+}
@@ -0,0 +1,169 @@
+package transcoder
+
+import (
+	"bufio"
+	"github.com/Vilsol/transcoder-go/models"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var flatParseRegex = regexp.MustCompile(`\s*(-?[0-9.]+).*`)
+
+// ProgressParser collects the key=value lines of ffmpeg -progress output into reports
+type ProgressParser struct {
+	report *models.ProgressReport
+}
+
+// ParseLine consumes one line and returns the finished report on progress=continue|end lines, nil otherwise
+func (parser *ProgressParser) ParseLine(line string) *models.ProgressReport {
+	key, value, found := strings.Cut(strings.TrimSpace(line), "=")
+	if !found {
+		return nil
+	}
+
+	key = strings.TrimSpace(key)
+	value = strings.TrimSpace(value)
+
+	if parser.report == nil {
+		parser.report = &models.ProgressReport{
+			StreamQuality: make(map[string]float64),
+			Values:        make(map[string]string),
+		}
+	}
+
+	report := parser.report
+	report.Values[key] = value
+
+	switch key {
+	case "frame":
+		report.Frame, _ = strconv.Atoi(value)
+	case "fps":
+		report.FPS, _ = strconv.ParseFloat(value, 64)
+	case "bitrate":
+		report.Bitrate = parseLeadingNumber(value)
+	case "total_size":
+		report.TotalSize, _ = strconv.Atoi(value)
+	case "out_time_us":
+		if us, err := strconv.ParseInt(value, 10, 64); err == nil {
+			report.OutTimeUs = us
+		}
+	case "out_time_ms":
+		// Despite the name ffmpeg reports microseconds here, out_time_us takes precedence when present
+		if _, ok := report.Values["out_time_us"]; !ok {
+			if us, err := strconv.ParseInt(value, 10, 64); err == nil {
+				report.OutTimeUs = us
+			}
+		}
+	case "out_time":
+		report.OutTime = value
+		if _, ok := report.Values["out_time_us"]; !ok {
+			if _, ok := report.Values["out_time_ms"]; !ok {
+				if us, ok := ParseOutTime(value); ok {
+					report.OutTimeUs = us
+				}
+			}
+		}
+	case "dup_frames":
+		report.DupFrames, _ = strconv.Atoi(value)
+	case "drop_frames":
+		report.DropFrames, _ = strconv.Atoi(value)
+	case "speed":
+		report.Speed = parseLeadingNumber(value)
+	case "progress":
+		report.Progress = value
+		parser.report = nil
+		return report
+	default:
+		if strings.HasPrefix(key, "stream_") && strings.HasSuffix(key, "_q") {
+			if quality, err := strconv.ParseFloat(value, 64); err == nil {
+				report.StreamQuality[strings.TrimSuffix(strings.TrimPrefix(key, "stream_"), "_q")] = quality
+			}
+		}
+	}
+
+	return nil
+}
+
+// ParseOutTime converts an ffmpeg HH:MM:SS.micros timestamp into microseconds
+func ParseOutTime(value string) (int64, bool) {
+	negative := strings.HasPrefix(value, "-")
+	split := strings.Split(strings.TrimPrefix(value, "-"), ":")
+	if len(split) != 3 {
+		return 0, false
+	}
+
+	hours, err := strconv.ParseInt(split[0], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	minutes, err := strconv.ParseInt(split[1], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	seconds, err := strconv.ParseFloat(split[2], 64)
+	if err != nil {
+		return 0, false
+	}
+
+	us := (hours*3600+minutes*60)*1000000 + int64(seconds*1000000+0.5)
+	if negative {
+		us = -us
+	}
+
+	return us, true
+}
+
+func parseLeadingNumber(value string) float64 {
+	matches := flatParseRegex.FindStringSubmatch(value)
+	if len(matches) < 2 {
+		return 0
+	}
+
+	parsed, _ := strconv.ParseFloat(matches[1], 64)
+	return parsed
+}
+
+// ReadProgress parses ffmpeg -progress output and calls onReport for every report until it returns false
+func ReadProgress(pipe io.Reader, onReport func(*models.ProgressReport) bool) {
+	parser := &ProgressParser{}
+
+	scanner := bufio.NewScanner(pipe)
+	for scanner.Scan() {
+		if report := parser.ParseLine(scanner.Text()); report != nil {
+			if !onReport(report) {
+				return
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil && !isClosedError(err) {
+		log.Errorf("Error reading stdout: %s", err)
+	}
+}
+
+// OutputToReport parses a single block of ffmpeg -progress output
+func OutputToReport(lines []string) *models.ProgressReport {
+	parser := &ProgressParser{}
+
+	for _, line := range lines {
+		if report := parser.ParseLine(line); report != nil {
+			return report
+		}
+	}
+
+	if parser.report == nil {
+		return &models.ProgressReport{}
+	}
+
+	return parser.report
+}
+
+func isClosedError(err error) bool {
+	return err == io.EOF || err == os.ErrClosed || strings.HasSuffix(err.Error(), "file already closed")
+}
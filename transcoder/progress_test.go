@@ -0,0 +1,199 @@
+package transcoder
+
+import (
+	"github.com/Vilsol/transcoder-go/models"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestReadProgress(t *testing.T) {
+	tests := []struct {
+		name     string
+		output   string
+		expected []models.ProgressReport
+	}{
+		{
+			name: "ffmpeg 3.4",
+			output: `frame=120
+fps=23.95
+stream_0_0_q=28.0
+bitrate= 412.3kbits/s
+total_size=258048
+out_time_ms=5005000
+out_time=00:00:05.005000
+dup_frames=0
+drop_frames=0
+speed=0.999x
+progress=continue
+frame=240
+fps=23.97
+stream_0_0_q=29.0
+bitrate= 398.1kbits/s
+total_size=498688
+out_time_ms=10010000
+out_time=00:00:10.010000
+dup_frames=0
+drop_frames=1
+speed=   1x
+progress=end
+`,
+			expected: []models.ProgressReport{
+				{Frame: 120, FPS: 23.95, Bitrate: 412.3, TotalSize: 258048, OutTime: "00:00:05.005000", OutTimeUs: 5005000, Speed: 0.999, Progress: "continue", StreamQuality: map[string]float64{"0_0": 28}},
+				{Frame: 240, FPS: 23.97, Bitrate: 398.1, TotalSize: 498688, OutTime: "00:00:10.010000", OutTimeUs: 10010000, DropFrames: 1, Speed: 1, Progress: "end", StreamQuality: map[string]float64{"0_0": 29}},
+			},
+		},
+		{
+			name: "ffmpeg 4.4 with out_time_us",
+			output: `frame=1532
+fps=63.83
+stream_0_0_q=33.1
+bitrate=1203.5kbits/s
+total_size=9633792
+out_time_us=64038000
+out_time_ms=64038000
+out_time=00:01:04.038000
+dup_frames=2
+drop_frames=0
+speed=2.67x
+progress=continue
+`,
+			expected: []models.ProgressReport{
+				{Frame: 1532, FPS: 63.83, Bitrate: 1203.5, TotalSize: 9633792, OutTime: "00:01:04.038000", OutTimeUs: 64038000, DupFrames: 2, Speed: 2.67, Progress: "continue", StreamQuality: map[string]float64{"0_0": 33.1}},
+			},
+		},
+		{
+			name: "ffmpeg 6.0 with multiple streams and N/A values",
+			output: `frame=0
+fps=0.00
+stream_0_0_q=0.0
+stream_0_1_q=-1.0
+bitrate=N/A
+total_size=N/A
+out_time_us=N/A
+out_time_ms=N/A
+out_time=N/A
+dup_frames=0
+drop_frames=0
+speed=N/A
+progress=continue
+frame=47
+fps=46.91
+stream_0_0_q=30.8
+stream_0_1_q=-1.0
+bitrate= 112.0kbits/s
+total_size=27433
+out_time_us=1959184
+out_time_ms=1959184
+out_time=00:00:01.959184
+dup_frames=0
+drop_frames=0
+speed=1.96x
+progress=continue
+`,
+			expected: []models.ProgressReport{
+				{OutTime: "N/A", Progress: "continue", StreamQuality: map[string]float64{"0_0": 0, "0_1": -1}},
+				{Frame: 47, FPS: 46.91, Bitrate: 112, TotalSize: 27433, OutTime: "00:00:01.959184", OutTimeUs: 1959184, Speed: 1.96, Progress: "continue", StreamQuality: map[string]float64{"0_0": 30.8, "0_1": -1}},
+			},
+		},
+		{
+			name: "ffmpeg 7.0 with negative start and windows line endings",
+			output: "frame=0\r\nfps=0.00\r\nstream_0_0_q=0.0\r\nbitrate=  -0.0kbits/s\r\ntotal_size=0\r\n" +
+				"out_time_us=-23220\r\nout_time_ms=-23220\r\nout_time=-00:00:00.023220\r\ndup_frames=0\r\ndrop_frames=0\r\n" +
+				"speed=N/A\r\nprogress=continue\r\n",
+			expected: []models.ProgressReport{
+				{OutTime: "-00:00:00.023220", OutTimeUs: -23220, Progress: "continue", StreamQuality: map[string]float64{"0_0": 0}},
+			},
+		},
+		{
+			name:     "truncated block",
+			output:   "frame=12\nfps=11.5\n",
+			expected: []models.ProgressReport{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reports := make([]*models.ProgressReport, 0)
+			ReadProgress(io.NopCloser(strings.NewReader(test.output)), func(report *models.ProgressReport) bool {
+				reports = append(reports, report)
+				return true
+			})
+
+			if len(reports) != len(test.expected) {
+				t.Fatalf("expected %d reports, got %d", len(test.expected), len(reports))
+			}
+
+			for i, expected := range test.expected {
+				assertReport(t, expected, *reports[i])
+			}
+		})
+	}
+}
+
+func TestReadProgressStops(t *testing.T) {
+	output := "frame=1\nprogress=continue\nframe=2\nprogress=continue\nframe=3\nprogress=end\n"
+
+	count := 0
+	ReadProgress(io.NopCloser(strings.NewReader(output)), func(report *models.ProgressReport) bool {
+		count++
+		return false
+	})
+
+	if count != 1 {
+		t.Fatalf("expected reading to stop after 1 report, got %d", count)
+	}
+}
+
+func TestParseOutTime(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected int64
+		ok       bool
+	}{
+		{value: "00:00:05.005000", expected: 5005000, ok: true},
+		{value: "01:30:00.000000", expected: 5400000000, ok: true},
+		{value: "-00:00:00.023220", expected: -23220, ok: true},
+		{value: "N/A", ok: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			us, ok := ParseOutTime(test.value)
+			if ok != test.ok || us != test.expected {
+				t.Errorf("expected %d (%v), got %d (%v)", test.expected, test.ok, us, ok)
+			}
+		})
+	}
+}
+
+func assertReport(t *testing.T, expected models.ProgressReport, actual models.ProgressReport) {
+	t.Helper()
+
+	if expected.Frame != actual.Frame || expected.FPS != actual.FPS || expected.Bitrate != actual.Bitrate ||
+		expected.TotalSize != actual.TotalSize || expected.Speed != actual.Speed || expected.Progress != actual.Progress {
+		t.Errorf("expected %+v, got %+v", expected, actual)
+	}
+
+	if expected.OutTime != actual.OutTime || expected.OutTimeUs != actual.OutTimeUs {
+		t.Errorf("expected out time %s (%d), got %s (%d)", expected.OutTime, expected.OutTimeUs, actual.OutTime, actual.OutTimeUs)
+	}
+
+	if expected.DupFrames != actual.DupFrames || expected.DropFrames != actual.DropFrames {
+		t.Errorf("expected %d dup and %d drop frames, got %d and %d", expected.DupFrames, expected.DropFrames, actual.DupFrames, actual.DropFrames)
+	}
+
+	if len(expected.StreamQuality) != len(actual.StreamQuality) {
+		t.Errorf("expected stream quality %v, got %v", expected.StreamQuality, actual.StreamQuality)
+	}
+
+	for stream, quality := range expected.StreamQuality {
+		if actual.StreamQuality[stream] != quality {
+			t.Errorf("expected stream %s quality %f, got %f", stream, quality, actual.StreamQuality[stream])
+		}
+	}
+
+	if _, ok := actual.Values["progress"]; !ok {
+		t.Errorf("expected raw values to include progress, got %v", actual.Values)
+	}
+}
@@ -0,0 +1,244 @@
+package transcoder
+
+import (
+	"fmt"
+	"github.com/Vilsol/transcoder-go/cache"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type crfResult struct {
+	CRF  int     `json:"crf"`
+	VMAF float64 `json:"vmaf"`
+}
+
+var vmafScoreRegex = regexp.MustCompile(`VMAF score\s*[:=]\s*([0-9.]+)`)
+
+// PrepareCRF searches for the highest CRF whose sample encodes still reach the target VMAF score
+func PrepareCRF(job *Job) error {
+	target := viper.GetFloat64("target-vmaf")
+	if target <= 0 || job.Bitrate > 0 {
+		return nil
+	}
+
+	candidates := viper.GetIntSlice("crf-candidates")
+	if len(candidates) == 0 {
+		return errors.New("target-vmaf requires at least one crf candidate")
+	}
+
+	sort.Ints(candidates)
+
+	duration, _ := strconv.ParseFloat(job.Metadata.Format.Duration, 64)
+	if duration <= 0 {
+		return errors.New("cannot search crf without a known duration")
+	}
+
+	cacheKey, err := cache.FileKey(job.FileName,
+		strconv.FormatFloat(target, 'f', -1, 64),
+		fmt.Sprint(candidates),
+		viper.GetString("flags"),
+		strings.Join(job.VideoFilters, ","),
+	)
+	if err != nil {
+		return errors.Wrap(err, "failed building cache key")
+	}
+
+	store := cache.Open("crf")
+
+	var result crfResult
+	if store.Get(cacheKey, &result) {
+		log.Infof("Using cached CRF %d for %s (VMAF %.2f)", result.CRF, job.FileName, result.VMAF)
+		job.CRF = result.CRF
+		job.Details = append(job.Details, fmt.Sprintf("CRF: %d (VMAF %.2f, cached)", result.CRF, result.VMAF))
+		return nil
+	}
+
+	result, err = searchCRF(candidates, target, func(crf int) (float64, error) {
+		score, err := scoreCRF(job, crf, duration)
+		if err == nil {
+			log.Infof("CRF %d of %s scored VMAF %.2f", crf, job.FileName, score)
+		}
+
+		return score, err
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Selected CRF %d for %s (VMAF %.2f, target %.2f)", result.CRF, job.FileName, result.VMAF, target)
+
+	if err := store.Set(cacheKey, result); err != nil {
+		log.Errorf("Error caching CRF of %s: %s", job.FileName, err)
+	}
+
+	job.CRF = result.CRF
+	job.Details = append(job.Details, fmt.Sprintf("CRF: %d (VMAF %.2f)", result.CRF, result.VMAF))
+
+	return nil
+}
+
+// searchCRF returns the highest of the sorted candidates whose score reaches the target.
+// It is a binary search assuming quality decreases monotonically with CRF, the lowest candidate is the fallback.
+func searchCRF(candidates []int, target float64, score func(crf int) (float64, error)) (crfResult, error) {
+	result := crfResult{CRF: candidates[0]}
+
+	low, high := 0, len(candidates)-1
+	for low <= high {
+		middle := (low + high) / 2
+
+		vmaf, err := score(candidates[middle])
+		if err != nil {
+			return crfResult{}, err
+		}
+
+		if vmaf >= target {
+			result = crfResult{CRF: candidates[middle], VMAF: vmaf}
+			low = middle + 1
+		} else {
+			if middle == 0 {
+				result.VMAF = vmaf
+			}
+			high = middle - 1
+		}
+	}
+
+	return result, nil
+}
+
+// scoreCRF encodes samples spread across the file and returns their mean VMAF score
+func scoreCRF(job *Job, crf int, duration float64) (float64, error) {
+	samples := viper.GetInt("crf-samples")
+	if samples < 1 {
+		samples = 1
+	}
+
+	length := viper.GetFloat64("crf-sample-length")
+	sampleFile := job.TempFileName + ".sample.mkv"
+	defer os.Remove(sampleFile)
+
+	total := 0.0
+	for i := 0; i < samples; i++ {
+		start := strconv.FormatFloat(duration*float64(i+1)/float64(samples+1), 'f', 3, 64)
+		sampleLength := strconv.FormatFloat(length, 'f', 3, 64)
+
+		encodeParams := append([]string{"-y"}, ffmpegLogFlags()...)
+		encodeParams = append(encodeParams, "-ss", start, "-t", sampleLength, "-i", job.FileName, "-map", "0:v:0")
+		encodeParams = append(encodeParams, withVideoFilters(withCRF(strings.Split(viper.GetString("flags"), " "), crf), job.VideoFilters)...)
+		encodeParams = append(encodeParams, "-an", "-sn", "-dn", "-f", "matroska", sampleFile)
+
+		if out, err := runFFmpeg(encodeParams); err != nil {
+			return 0, errors.Wrapf(err, "failed encoding crf %d sample: %s", crf, lastLine(string(out)))
+		}
+
+		reference := "[1:v]null[ref]"
+		if len(job.VideoFilters) > 0 {
+			reference = "[1:v]" + strings.Join(job.VideoFilters, ",") + "[ref]"
+		}
+
+		scoreParams := []string{
+			"-hide_banner", "-nostats",
+			"-i", sampleFile,
+			"-ss", start, "-t", sampleLength, "-i", job.FileName,
+			"-lavfi", reference + ";[0:v][ref]libvmaf",
+			"-f", "null", "-",
+		}
+
+		out, err := runFFmpeg(scoreParams)
+		if err != nil {
+			return 0, errors.Wrapf(err, "failed scoring crf %d sample: %s", crf, lastLine(string(out)))
+		}
+
+		score, ok := ParseVMAFScore(string(out))
+		if !ok {
+			return 0, fmt.Errorf("no vmaf score in ffmpeg output for crf %d", crf)
+		}
+
+		total += score
+	}
+
+	return total / float64(samples), nil
+}
+
+// ParseVMAFScore returns the pooled score libvmaf prints when it finishes
+func ParseVMAFScore(output string) (float64, bool) {
+	matches := vmafScoreRegex.FindStringSubmatch(output)
+	if len(matches) < 2 {
+		return 0, false
+	}
+
+	score, err := strconv.ParseFloat(matches[1], 64)
+
+	return score, err == nil
+}
+
+// withCRF replaces the CRF in the configured flags, wherever it was set
+func withCRF(flags []string, crf int) []string {
+	merged := make([]string, len(flags))
+	copy(merged, flags)
+
+	value := strconv.Itoa(crf)
+
+	for i, flag := range merged {
+		if flag == "-crf" && i+1 < len(merged) {
+			merged[i+1] = value
+			return merged
+		}
+	}
+
+	for i, flag := range merged {
+		if flag == "-x265-params" && i+1 < len(merged) {
+			params := strings.Split(merged[i+1], ":")
+			for j, param := range params {
+				if strings.HasPrefix(param, "crf=") {
+					params[j] = "crf=" + value
+					merged[i+1] = strings.Join(params, ":")
+					return merged
+				}
+			}
+		}
+	}
+
+	return append(merged, "-crf", value)
+}
+
+// withoutCRF removes any CRF from the configured flags, so a target bitrate takes effect
+func withoutCRF(flags []string) []string {
+	stripped := make([]string, 0, len(flags))
+
+	for i := 0; i < len(flags); i++ {
+		if flags[i] == "-crf" && i+1 < len(flags) {
+			i++
+			continue
+		}
+
+		if flags[i] == "-x265-params" && i+1 < len(flags) {
+			params := make([]string, 0)
+			for _, param := range strings.Split(flags[i+1], ":") {
+				if !strings.HasPrefix(param, "crf=") {
+					params = append(params, param)
+				}
+			}
+
+			i++
+			if len(params) > 0 {
+				stripped = append(stripped, "-x265-params", strings.Join(params, ":"))
+			}
+			continue
+		}
+
+		stripped = append(stripped, flags[i])
+	}
+
+	return stripped
+}
+
+func lastLine(output string) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	return lines[len(lines)-1]
+}
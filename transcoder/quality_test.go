@@ -0,0 +1,111 @@
+package transcoder
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSearchCRF(t *testing.T) {
+	candidates := []int{16, 18, 20, 22, 24, 26, 28}
+
+	tests := []struct {
+		name       string
+		candidates []int
+		target     float64
+		expected   crfResult
+	}{
+		{name: "highest passing candidate", candidates: candidates, target: 80, expected: crfResult{CRF: 20, VMAF: 80}},
+		{name: "every candidate passes", candidates: candidates, target: 70, expected: crfResult{CRF: 28, VMAF: 72}},
+		{name: "lowest candidate as fallback", candidates: candidates, target: 90, expected: crfResult{CRF: 16, VMAF: 84}},
+		{name: "single candidate", candidates: []int{20}, target: 90, expected: crfResult{CRF: 20, VMAF: 80}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scored := 0
+
+			result, err := searchCRF(test.candidates, test.target, func(crf int) (float64, error) {
+				scored++
+				return float64(100 - crf), nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if result != test.expected {
+				t.Errorf("expected %+v, got %+v", test.expected, result)
+			}
+
+			if scored > 3 {
+				t.Errorf("expected at most 3 scored candidates, got %d", scored)
+			}
+		})
+	}
+}
+
+func TestSearchCRFError(t *testing.T) {
+	_, err := searchCRF([]int{16, 18, 20}, 90, func(crf int) (float64, error) {
+		return 0, errors.New("libvmaf missing")
+	})
+
+	if err == nil {
+		t.Fatal("expected the scoring error to be returned")
+	}
+}
+
+func TestParseVMAFScore(t *testing.T) {
+	tests := []struct {
+		name     string
+		output   string
+		expected float64
+		ok       bool
+	}{
+		{
+			name:     "libvmaf 2",
+			output:   "[Parsed_libvmaf_1 @ 0x5581c2] VMAF score: 95.284375\n",
+			expected: 95.284375,
+			ok:       true,
+		},
+		{
+			name:     "libvmaf 1",
+			output:   "[libvmaf @ 0x5581c2] VMAF score = 93.120034\n",
+			expected: 93.120034,
+			ok:       true,
+		},
+		{
+			name:   "no score",
+			output: "[AVFilterGraph @ 0x5581c2] No such filter: 'libvmaf'\n",
+			ok:     false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			score, ok := ParseVMAFScore(test.output)
+			if ok != test.ok || score != test.expected {
+				t.Errorf("expected %f (%v), got %f (%v)", test.expected, test.ok, score, ok)
+			}
+		})
+	}
+}
+
+func TestWithCRF(t *testing.T) {
+	tests := []struct {
+		flags    string
+		expected string
+	}{
+		{flags: "-c:v libx264 -crf 23", expected: "-c:v libx264 -crf 18"},
+		{flags: "-c:v libx265 -x265-params crf=16:aq-mode=3", expected: "-c:v libx265 -x265-params crf=18:aq-mode=3"},
+		{flags: "-c:v libsvtav1", expected: "-c:v libsvtav1 -crf 18"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.flags, func(t *testing.T) {
+			actual := strings.Join(withCRF(strings.Split(test.flags, " "), 18), " ")
+			if actual != test.expected {
+				t.Errorf("expected %s, got %s", test.expected, actual)
+			}
+		})
+	}
+}
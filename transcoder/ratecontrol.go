@@ -0,0 +1,158 @@
+package transcoder
+
+import (
+	"fmt"
+	"github.com/Vilsol/transcoder-go/utils"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"strconv"
+	"strings"
+)
+
+var ErrTargetNotSmaller = errors.New("target size is not smaller than the original")
+
+// PrepareRateControl switches the job to two-pass ABR when a size target is configured,
+// or caps the bitrate of CRF encodes when a maximum bitrate is configured
+func PrepareRateControl(job *Job) error {
+	duration, _ := strconv.ParseFloat(job.Metadata.Format.Duration, 64)
+
+	totalBitrate, err := targetBitrate(duration)
+	if err != nil {
+		return err
+	}
+
+	if totalBitrate > 0 {
+		job.TargetSize = int64(float64(totalBitrate) / 8 * duration)
+
+		if viper.GetBool("keep-old") && job.TargetSize >= job.Metadata.Format.SizeInt() {
+			return ErrTargetNotSmaller
+		}
+
+		videoBitrate := totalBitrate - audioBitrate(job)
+		if videoBitrate <= 0 {
+			return fmt.Errorf("target bitrate %s does not leave room for video next to %s of audio",
+				utils.BitsHumanReadable(totalBitrate), utils.BitsHumanReadable(audioBitrate(job)))
+		}
+
+		log.Infof("Encoding %s in two passes at %s to reach %s",
+			job.FileName, utils.BitsHumanReadable(videoBitrate), utils.BytesHumanReadable(job.TargetSize))
+
+		job.Bitrate = videoBitrate
+		job.Details = append(job.Details, "Target size: "+utils.BytesHumanReadable(job.TargetSize))
+
+		return nil
+	}
+
+	if maxBitrate := viper.GetString("max-bitrate"); maxBitrate != "" {
+		parsed, err := utils.ParseHumanSize(maxBitrate)
+		if err != nil {
+			return errors.Wrap(err, "invalid max-bitrate")
+		}
+
+		job.ExtraFlags = append(job.ExtraFlags,
+			"-maxrate", strconv.FormatInt(parsed, 10),
+			"-bufsize", strconv.FormatInt(parsed*2, 10),
+		)
+		job.Details = append(job.Details, "Max bitrate: "+maxBitrate)
+	}
+
+	return nil
+}
+
+// targetBitrate returns the total bitrate needed to meet the configured targets, 0 if there are none
+func targetBitrate(duration float64) (int64, error) {
+	bitrate := int64(0)
+
+	if target := viper.GetString("target-bitrate"); target != "" {
+		parsed, err := utils.ParseHumanSize(target)
+		if err != nil {
+			return 0, errors.Wrap(err, "invalid target-bitrate")
+		}
+
+		bitrate = parsed
+	}
+
+	if target := viper.GetString("target-size-per-hour"); target != "" {
+		parsed, err := utils.ParseHumanSize(target)
+		if err != nil {
+			return 0, errors.Wrap(err, "invalid target-size-per-hour")
+		}
+
+		perHour := parsed * 8 / 3600
+		if bitrate == 0 || perHour < bitrate {
+			bitrate = perHour
+		}
+	}
+
+	if bitrate > 0 && duration <= 0 {
+		return 0, errors.New("size targets require a known duration")
+	}
+
+	return bitrate, nil
+}
+
+// audioBitrate estimates the bitrate of all audio in the output from the configured -b:a
+func audioBitrate(job *Job) int64 {
+	perStream := int64(128000)
+
+	flags := strings.Split(viper.GetString("flags"), " ")
+	for i, flag := range flags {
+		if flag == "-b:a" && i+1 < len(flags) {
+			if parsed, err := utils.ParseHumanSize(flags[i+1]); err == nil {
+				perStream = parsed
+			}
+		}
+	}
+
+	streams := 0
+	for _, stream := range OutputStreams(job.Streams) {
+		if stream.Stream.CodecType == "audio" {
+			streams++
+		}
+	}
+
+	return perStream * int64(streams)
+}
+
+// PrepareFirstPass runs the first pass of two-pass encodes. It has to follow every step that changes the video flags,
+// so that both passes encode with the same x265 parameters, filters and color flags.
+func PrepareFirstPass(job *Job) error {
+	if job.Bitrate <= 0 {
+		return nil
+	}
+
+	statsFile := job.TempFileName + ".pass"
+
+	firstPass := *job
+	firstPass.X265Params = append([]string{}, job.X265Params...)
+
+	if usesX265() {
+		job.TempFiles = append(job.TempFiles, statsFile, statsFile+".cutree", statsFile+".temp", statsFile+".cutree.temp")
+		firstPass.X265Params = append(firstPass.X265Params, "pass=1", "stats="+statsFile)
+	} else {
+		job.TempFiles = append(job.TempFiles, statsFile+"-0.log", statsFile+"-0.log.mbtree")
+	}
+
+	params := append([]string{"-y"}, ffmpegLogFlags()...)
+	params = append(params, "-i", job.FileName, "-map", "0:v:0")
+	params = append(params, videoFlags(&firstPass)...)
+	params = append(params, colorFlags(job)...)
+	if !usesX265() {
+		params = append(params, "-pass", "1", "-passlogfile", statsFile)
+	}
+	params = append(params, "-an", "-sn", "-dn", "-f", "null", "-")
+
+	log.Infof("Running first pass of %s", job.FileName)
+	if out, err := runFFmpeg(params); err != nil {
+		return errors.Wrapf(err, "first pass failed: %s", lastLine(string(out)))
+	}
+
+	if usesX265() {
+		job.X265Params = append(job.X265Params, "pass=2", "stats="+statsFile)
+	} else {
+		job.ExtraFlags = append(job.ExtraFlags, "-pass", "2", "-passlogfile", statsFile)
+	}
+
+	return nil
+}
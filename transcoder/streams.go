@@ -0,0 +1,242 @@
+package transcoder
+
+import (
+	"fmt"
+	"github.com/Vilsol/transcoder-go/models"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+type StreamAction string
+
+const (
+	StreamKeep    = StreamAction("keep")
+	StreamDrop    = StreamAction("drop")
+	StreamSRT     = StreamAction("srt")
+	StreamASS     = StreamAction("ass")
+	StreamMovText = StreamAction("mov_text")
+	StreamWebVTT  = StreamAction("webvtt")
+	StreamExtract = StreamAction("extract")
+)
+
+var streamTypes = []string{"video", "audio", "subtitle", "data", "attachment"}
+
+var bitmapSubtitleCodecs = map[string]bool{
+	"hdmv_pgs_subtitle": true,
+	"dvd_subtitle":      true,
+	"dvb_subtitle":      true,
+	"xsub":              true,
+}
+
+var subtitleExtensions = map[string]string{
+	"subrip":            "srt",
+	"ass":               "ass",
+	"ssa":               "ssa",
+	"webvtt":            "vtt",
+	"hdmv_pgs_subtitle": "sup",
+}
+
+// StreamRule is parsed from type[:language[:codec]]=action, where language and codec may be "*"
+type StreamRule struct {
+	Type     string
+	Language string
+	Codec    string
+	Action   StreamAction
+}
+
+type StreamPlan struct {
+	Stream models.Stream
+	Action StreamAction
+}
+
+func ParseStreamRules(rules []string) ([]StreamRule, error) {
+	parsed := make([]StreamRule, 0, len(rules))
+
+	for _, rule := range rules {
+		split := strings.SplitN(rule, "=", 2)
+		if len(split) != 2 {
+			return nil, fmt.Errorf("invalid stream rule %s: missing action", rule)
+		}
+
+		selector := strings.Split(strings.TrimSpace(split[0]), ":")
+		if len(selector) > 3 {
+			return nil, fmt.Errorf("invalid stream rule %s: too many selectors", rule)
+		}
+
+		parsedRule := StreamRule{
+			Type:     selector[0],
+			Language: "*",
+			Codec:    "*",
+			Action:   StreamAction(strings.TrimSpace(split[1])),
+		}
+
+		if len(selector) > 1 && selector[1] != "" {
+			parsedRule.Language = selector[1]
+		}
+
+		if len(selector) > 2 && selector[2] != "" {
+			parsedRule.Codec = selector[2]
+		}
+
+		validType := false
+		for _, streamType := range streamTypes {
+			if parsedRule.Type == streamType {
+				validType = true
+				break
+			}
+		}
+
+		if !validType {
+			return nil, fmt.Errorf("invalid stream rule %s: unknown stream type %s", rule, parsedRule.Type)
+		}
+
+		switch parsedRule.Action {
+		case StreamKeep, StreamDrop:
+		case StreamSRT, StreamASS, StreamMovText, StreamWebVTT, StreamExtract:
+			if parsedRule.Type != "subtitle" {
+				return nil, fmt.Errorf("invalid stream rule %s: %s is only supported for subtitles", rule, parsedRule.Action)
+			}
+		default:
+			return nil, fmt.Errorf("invalid stream rule %s: unknown action %s", rule, parsedRule.Action)
+		}
+
+		parsed = append(parsed, parsedRule)
+	}
+
+	return parsed, nil
+}
+
+// Codec returns the codec name ffprobe reports for subtitles converted with the action
+func (action StreamAction) Codec() string {
+	if action == StreamSRT {
+		return "subrip"
+	}
+
+	return string(action)
+}
+
+func (rule StreamRule) Matches(stream models.Stream) bool {
+	if rule.Type != stream.CodecType {
+		return false
+	}
+
+	if rule.Language != "*" && rule.Language != stream.Language() {
+		return false
+	}
+
+	if rule.Codec != "*" && rule.Codec != stream.CodecName {
+		return false
+	}
+
+	return true
+}
+
+// PlanStreams decides what happens to every stream of the input based on the configured stream rules.
+// The first matching rule wins, streams without a matching rule are kept.
+func PlanStreams(metadata *models.FileMetadata) ([]StreamPlan, error) {
+	rules, err := ParseStreamRules(viper.GetStringSlice("streams"))
+	if err != nil {
+		return nil, err
+	}
+
+	container := OutputContainer()
+
+	plan := make([]StreamPlan, 0, len(metadata.Streams))
+	for _, stream := range metadata.Streams {
+		action := StreamKeep
+		for _, rule := range rules {
+			if rule.Matches(stream) {
+				action = rule.Action
+				break
+			}
+		}
+
+		if err := container.Check(stream, action); err != nil {
+			return nil, err
+		}
+
+		plan = append(plan, StreamPlan{
+			Stream: stream,
+			Action: action,
+		})
+	}
+
+	return plan, nil
+}
+
+// OutputStreams returns the streams that end up in the output, in output order
+func OutputStreams(plan []StreamPlan) []StreamPlan {
+	output := make([]StreamPlan, 0, len(plan))
+
+	for _, stream := range plan {
+		switch stream.Action {
+		case StreamKeep, StreamSRT, StreamASS, StreamMovText, StreamWebVTT:
+			output = append(output, stream)
+		}
+	}
+
+	return output
+}
+
+// StreamFlags maps every kept stream explicitly and sets the output codec of converted subtitles.
+// The stream at chunkedIndex is taken from the concatenated chunks in the second input instead, -1 if there is none.
+func StreamFlags(plan []StreamPlan, chunkedIndex int) []string {
+	flags := make([]string, 0)
+
+	for outputIndex, stream := range OutputStreams(plan) {
+		if stream.Stream.Index == chunkedIndex {
+			flags = append(flags, "-map", "1:v:0")
+		} else {
+			flags = append(flags, "-map", "0:"+strconv.Itoa(stream.Stream.Index))
+		}
+
+		if stream.Action != StreamKeep {
+			flags = append(flags, "-c:"+strconv.Itoa(outputIndex), string(stream.Action))
+		}
+	}
+
+	return flags
+}
+
+// ExtractStreams writes every subtitle with the extract action to a sidecar file next to the output
+func ExtractStreams(fileName string, outputFileName string, plan []StreamPlan) error {
+	stem := strings.TrimSuffix(outputFileName, filepath.Ext(outputFileName))
+	used := make(map[string]bool)
+
+	for _, stream := range plan {
+		if stream.Action != StreamExtract {
+			continue
+		}
+
+		extension, ok := subtitleExtensions[stream.Stream.CodecName]
+		codec := "copy"
+		if !ok {
+			// Text subtitles without a sidecar format of their own are converted to srt
+			extension = "srt"
+			codec = "srt"
+		}
+
+		sidecar := stem + "." + stream.Stream.Language() + "." + extension
+		if used[sidecar] {
+			sidecar = stem + "." + stream.Stream.Language() + "." + strconv.Itoa(stream.Stream.Index) + "." + extension
+		}
+		used[sidecar] = true
+
+		params := append([]string{"-y"}, ffmpegLogFlags()...)
+		params = append(params, "-i", fileName, "-map", "0:"+strconv.Itoa(stream.Stream.Index), "-c", codec, sidecar)
+
+		if out, err := runFFmpeg(params); err != nil {
+			_ = os.Remove(sidecar)
+			return errors.Wrapf(err, "failed extracting stream %d: %s", stream.Stream.Index, lastLine(string(out)))
+		}
+
+		log.Infof("Extracted stream %d to %s", stream.Stream.Index, sidecar)
+	}
+
+	return nil
+}
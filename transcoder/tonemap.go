@@ -0,0 +1,45 @@
+package transcoder
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"strconv"
+)
+
+// PrepareTonemap adds an HDR to SDR tonemapping filter chain if the source is HDR and tonemapping is enabled
+func PrepareTonemap(job *Job) error {
+	if !viper.GetBool("tonemap") {
+		return nil
+	}
+
+	video := job.Metadata.VideoStream()
+	if video == nil || !IsHDR(video) {
+		return nil
+	}
+
+	switch viper.GetString("tonemap-algorithm") {
+	case "none", "clip", "linear", "gamma", "reinhard", "hable", "mobius":
+	default:
+		return fmt.Errorf("unknown tonemap algorithm %s", viper.GetString("tonemap-algorithm"))
+	}
+
+	filter := TonemapFilter(viper.GetString("tonemap-algorithm"), viper.GetFloat64("tonemap-peak"), viper.GetString("tonemap-pix-fmt"))
+
+	log.Infof("Tonemapping %s from %s to bt709", job.FileName, *video.ColorTransfer)
+
+	job.VideoFilters = append(job.VideoFilters, filter)
+	job.Tonemapped = true
+
+	return nil
+}
+
+// TonemapFilter linearizes the source with zscale, tonemaps it in floating point and converts it to bt709
+func TonemapFilter(algorithm string, peak float64, pixelFormat string) string {
+	return "zscale=t=linear:npl=" + strconv.FormatFloat(peak, 'f', -1, 64) +
+		",format=gbrpf32le" +
+		",zscale=p=bt709" +
+		",tonemap=tonemap=" + algorithm + ":desat=0" +
+		",zscale=t=bt709:m=bt709:r=tv" +
+		",format=" + pixelFormat
+}
@@ -1,16 +1,16 @@
 package transcoder
 
 import (
+	"github.com/Vilsol/transcoder-go/joblog"
 	"github.com/Vilsol/transcoder-go/models"
 	"github.com/Vilsol/transcoder-go/notifications"
-	"github.com/Vilsol/transcoder-go/utils"
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 	"io"
 	"os"
 	"os/exec"
 	"os/signal"
-	"regexp"
 	"runtime"
 	"strconv"
 	"strings"
@@ -20,71 +20,283 @@ import (
 
 var lastReport *models.ProgressReport
 
-func BuildFlags(fileName string, tempFileName string, metadata *models.FileMetadata) []string {
+func BuildFlags(job *Job) []string {
 	finalFlags := make([]string, 0)
 
-	if viper.GetBool("nice") && runtime.GOOS == "linux" {
-		finalFlags = append(finalFlags, "ffmpeg")
-	}
-
 	// The input file
-	finalFlags = append(finalFlags, "-y", "-i", fileName)
+	finalFlags = append(finalFlags, "-y", "-i", job.FileName)
 
-	if !viper.GetBool("stderr") {
-		// Add quiet flag
-		finalFlags = append(finalFlags, "-v", "quiet")
+	if job.VideoSource != "" {
+		// Video encoded in chunks
+		finalFlags = append(finalFlags, "-f", "concat", "-safe", "0", "-i", job.VideoSource)
 	}
 
+	finalFlags = append(finalFlags, ffmpegLogFlags()...)
+
+	container := OutputContainer()
+
 	// Mandatory flags
-	finalFlags = append(finalFlags, "-c", "copy", "-f", "matroska", "-progress", "-")
+	finalFlags = append(finalFlags, "-c", "copy", "-f", container.Format, "-progress", "-")
+	finalFlags = append(finalFlags, container.Flags...)
 
 	// Configurable flags
-	finalFlags = append(finalFlags, strings.Split(viper.GetString("flags"), " ")...)
-
-	// Add flags from original
-	if metadata != nil {
-		for _, stream := range metadata.Streams {
-			if stream.CodecType == "video" {
-				if stream.ColorPrimaries != nil {
-					finalFlags = append(finalFlags, "-color_primaries", *stream.ColorPrimaries)
-				}
-				if stream.ColorRange != nil {
-					finalFlags = append(finalFlags, "-color_range", *stream.ColorRange)
-				}
-				if stream.ColorSpace != nil {
-					finalFlags = append(finalFlags, "-colorspace", *stream.ColorSpace)
-				}
-				if stream.ColorTransfer != nil {
-					finalFlags = append(finalFlags, "-color_trc", *stream.ColorTransfer)
-				}
-				if stream.PixelFormat != nil {
-					finalFlags = append(finalFlags, "-pix_fmt", *stream.PixelFormat)
-				}
-				break
-			}
-		}
+	configFlags := strings.Split(viper.GetString("flags"), " ")
+
+	if job.VideoSource != "" {
+		// The video is already encoded, only the remaining streams are processed
+		configFlags = append(withoutVideoFilters(configFlags), "-c:v", "copy")
+		finalFlags = append(finalFlags, configFlags...)
+		finalFlags = append(finalFlags, StreamFlags(job.Streams, job.Metadata.VideoStream().Index)...)
+		finalFlags = append(finalFlags, MetadataFlags(job, true)...)
+		return append(finalFlags, job.TempFileName)
 	}
 
+	finalFlags = append(finalFlags, videoFlags(job)...)
+
+	// Stream mapping, unless the configured flags already map streams themselves
+	mapped := !containsFlag(configFlags, "-map")
+	if mapped {
+		finalFlags = append(finalFlags, StreamFlags(job.Streams, -1)...)
+	} else {
+		log.Warningf("Stream rules are not applied to %s, the configured flags map streams with -map", job.FileName)
+	}
+
+	finalFlags = append(finalFlags, MetadataFlags(job, mapped)...)
+	finalFlags = append(finalFlags, colorFlags(job)...)
+
 	// The output file
-	finalFlags = append(finalFlags, tempFileName)
+	finalFlags = append(finalFlags, job.TempFileName)
 
 	return finalFlags
 }
 
-func TranscodeFile(fileName string, tempFileName string, metadata *models.FileMetadata, skip chan bool) (bool, *models.ProgressReport, bool) {
-	flags := BuildFlags(fileName, tempFileName, metadata)
+// videoFlags are the flags that encode the video stream, shared by full and chunked encodes
+func videoFlags(job *Job) []string {
+	configFlags := strings.Split(viper.GetString("flags"), " ")
 
-	notifications.NotifyStart(metadata)
+	if job.Bitrate > 0 {
+		configFlags = append(withoutCRF(configFlags), "-b:v", strconv.FormatInt(job.Bitrate, 10))
+	} else if job.CRF > 0 {
+		configFlags = withCRF(configFlags, job.CRF)
+	}
 
-	log.Tracef("Executing ffmpeg %s", strings.Join(flags, " "))
+	flags := withVideoFilters(withX265Params(configFlags, job.X265Params), job.VideoFilters)
+	flags = append(flags, job.ExtraFlags...)
+
+	return flags
+}
+
+func colorFlags(job *Job) []string {
+	if job.Tonemapped {
+		// Tonemapped output is always SDR
+		return []string{"-color_primaries", "bt709", "-color_range", "tv", "-colorspace", "bt709", "-color_trc", "bt709", "-pix_fmt", viper.GetString("tonemap-pix-fmt")}
+	}
+
+	flags := make([]string, 0)
+
+	if job.Metadata != nil {
+		// Add flags from original
+		if stream := job.Metadata.VideoStream(); stream != nil {
+			if stream.ColorPrimaries != nil {
+				flags = append(flags, "-color_primaries", *stream.ColorPrimaries)
+			}
+			if stream.ColorRange != nil {
+				flags = append(flags, "-color_range", *stream.ColorRange)
+			}
+			if stream.ColorSpace != nil {
+				flags = append(flags, "-colorspace", *stream.ColorSpace)
+			}
+			if stream.ColorTransfer != nil {
+				flags = append(flags, "-color_trc", *stream.ColorTransfer)
+			}
+			if stream.PixelFormat != nil {
+				flags = append(flags, "-pix_fmt", *stream.PixelFormat)
+			}
+		}
+	}
+
+	return flags
+}
+
+// ffmpegCommand runs ffmpeg with a lowered priority if configured
+func ffmpegCommand(flags []string) *exec.Cmd {
+	joblog.Command("ffmpeg", flags)
 
-	var c *exec.Cmd
 	if viper.GetBool("nice") && runtime.GOOS == "linux" {
-		c = exec.Command("nice", flags...)
-	} else {
-		c = exec.Command("ffmpeg", flags...)
+		return exec.Command("nice", append([]string{"ffmpeg"}, flags...)...)
+	}
+
+	return exec.Command("ffmpeg", flags...)
+}
+
+// ffmpegLogFlags keeps ffmpeg quiet unless its output goes to our stderr or the job log
+func ffmpegLogFlags() []string {
+	if joblog.Active() {
+		flags := []string{"-v", viper.GetString("ffmpeg-log-level")}
+		if !viper.GetBool("stderr") {
+			// Progress is read from -progress, the stats line would only fill the log
+			flags = append(flags, "-nostats")
+		}
+
+		return flags
+	}
+
+	if !viper.GetBool("stderr") {
+		return []string{"-v", "quiet"}
+	}
+
+	return nil
+}
+
+// attachStderr sends the stderr of ffmpeg to the job log, and to our stderr with --stderr.
+// The returned function writes the last line to the job log once the process exited.
+func attachStderr(c *exec.Cmd, fields log.Fields) func() {
+	writers := make([]io.Writer, 0)
+
+	if viper.GetBool("stderr") {
+		writers = append(writers, os.Stderr)
+	}
+
+	jobLog := joblog.Stderr(fields)
+	if jobLog != nil {
+		writers = append(writers, jobLog)
+	}
+
+	switch len(writers) {
+	case 0:
+		return func() {}
+	case 1:
+		c.Stderr = writers[0]
+	default:
+		c.Stderr = io.MultiWriter(writers...)
 	}
 
+	return func() {
+		if jobLog != nil {
+			_ = jobLog.Close()
+		}
+	}
+}
+
+// runFFmpeg runs a short analysis or extraction ffmpeg and returns its combined output, which is copied to the job log
+func runFFmpeg(params []string) ([]byte, error) {
+	log.Tracef("Executing ffmpeg %s", strings.Join(params, " "))
+	joblog.Command("ffmpeg", params)
+
+	out, err := exec.Command("ffmpeg", params...).CombinedOutput()
+	copyToJobLog(out, nil)
+
+	return out, err
+}
+
+// copyToJobLog writes the captured output of a finished process to the job log
+func copyToJobLog(out []byte, fields log.Fields) {
+	if jobLog := joblog.Stderr(fields); jobLog != nil {
+		_, _ = jobLog.Write(out)
+		_ = jobLog.Close()
+	}
+}
+
+// MapsStreams reports whether the configured flags select streams themselves, which replaces the stream rules
+func MapsStreams(flags string) bool {
+	return containsFlag(strings.Split(flags, " "), "-map")
+}
+
+func containsFlag(flags []string, flag string) bool {
+	for _, f := range flags {
+		if f == flag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// withoutVideoFilters removes the configured video filter chain
+func withoutVideoFilters(flags []string) []string {
+	stripped := make([]string, 0, len(flags))
+
+	for i := 0; i < len(flags); i++ {
+		if (flags[i] == "-vf" || flags[i] == "-filter:v") && i+1 < len(flags) {
+			i++
+			continue
+		}
+
+		stripped = append(stripped, flags[i])
+	}
+
+	return stripped
+}
+
+// withVideoFilters appends extra filters to the configured video filter chain, adding -vf if it is missing
+func withVideoFilters(flags []string, filters []string) []string {
+	if len(filters) == 0 {
+		return flags
+	}
+
+	merged := make([]string, len(flags))
+	copy(merged, flags)
+
+	for i, flag := range merged {
+		if (flag == "-vf" || flag == "-filter:v") && i+1 < len(merged) {
+			merged[i+1] = merged[i+1] + "," + strings.Join(filters, ",")
+			return merged
+		}
+	}
+
+	return append(merged, "-vf", strings.Join(filters, ","))
+}
+
+// withX265Params merges extra parameters into the configured -x265-params, adding the flag if it is missing
+func withX265Params(flags []string, params []string) []string {
+	if len(params) == 0 {
+		return flags
+	}
+
+	merged := make([]string, len(flags))
+	copy(merged, flags)
+
+	for i, flag := range merged {
+		if flag == "-x265-params" && i+1 < len(merged) {
+			merged[i+1] = merged[i+1] + ":" + strings.Join(params, ":")
+			return merged
+		}
+	}
+
+	return append(merged, "-x265-params", strings.Join(params, ":"))
+}
+
+func configuredX265Params() []string {
+	flags := strings.Split(viper.GetString("flags"), " ")
+
+	for i, flag := range flags {
+		if flag == "-x265-params" && i+1 < len(flags) {
+			return strings.Split(flags[i+1], ":")
+		}
+	}
+
+	return nil
+}
+
+func usesX265() bool {
+	return containsFlag(strings.Split(viper.GetString("flags"), " "), "libx265")
+}
+
+func TranscodeFile(job *Job, skip chan bool) (bool, *models.ProgressReport, bool) {
+	if len(job.Chunks) > 0 {
+		return TranscodeChunked(job, skip)
+	}
+
+	tempFileName := job.TempFileName
+
+	flags := BuildFlags(job)
+
+	notifications.NotifyStart(job.Metadata, job.OutputName, job.Details)
+
+	log.Tracef("Executing ffmpeg %s", strings.Join(flags, " "))
+
+	c := ffmpegCommand(flags)
+
 	done := make(chan bool, 2)
 	stopTranscoder := make(chan bool, 2)
 
@@ -96,22 +308,14 @@ func TranscodeFile(fileName string, tempFileName string, metadata *models.FileMe
 		log.Fatal(err)
 	}
 
-	errPipe, err := c.StderrPipe()
-	defer errPipe.Close()
-	if err != nil {
-		log.Fatal(err)
-	}
+	flushStderr := attachStderr(c, nil)
 
 	err = c.Start()
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	if viper.GetBool("stderr") {
-		go ReadError(errPipe)
-	}
-
-	go ReadOut(outPipe, fileName, metadata, stopTranscoder)
+	go ReadOut(outPipe, job, stopTranscoder)
 
 	skipping := false
 	go func() {
@@ -120,128 +324,53 @@ func TranscodeFile(fileName string, tempFileName string, metadata *models.FileMe
 	}()
 
 	err = c.Wait()
+	flushStderr()
 
-	if err != nil {
+	stopTranscoder <- false
+	killed := <-done
+
+	if err != nil && !killed {
+		// A requested kill is no failure, the job log of an early exit or skip is not kept
 		log.Errorf("ffmpeg: %s", err)
 	}
 
-	stopTranscoder <- false
-
-	return <-done, lastReport, skipping
+	return killed, lastReport, skipping
 }
 
-func ReadOut(pipe io.ReadCloser, filename string, metadata *models.FileMetadata, stopTranscoder chan bool) {
+func ReadOut(pipe io.ReadCloser, job *Job, stopTranscoder chan bool) {
+	sizeLimit := job.SizeLimit()
 	lastLog := int64(0)
-	lines := make([]string, 0)
-	line := make([]byte, 0)
-	for {
-		buffer := make([]byte, 1)
-		readCount, err := pipe.Read(buffer)
-
-		if readCount == 0 {
-			break
-		}
 
-		if buffer[0] != '\n' {
-			line = append(line, buffer[0])
-		} else {
-			lines = append(lines, string(line))
-			line = make([]byte, 0)
-
-			// TODO Progress report based on value detection
-			if len(lines) == 12 {
-				report := OutputToReport(lines)
-				lastReport = report
-
-				if viper.GetBool("early-exit") && viper.GetBool("keep-old") {
-					if int64(report.TotalSize) > metadata.Format.SizeInt() {
-						stopTranscoder <- true
-						return
-					}
-
-					if utils.SkipConfidenceMeta(metadata, report.Frame, report.TotalSize) > viper.GetFloat64("skip-confidence") {
-						stopTranscoder <- true
-						return
-					}
-				}
-
-				notifications.NotifyProgressStatus(report)
-
-				if time.Now().Unix()-lastLog > int64(viper.GetInt("interval")) {
-					report.Log(filename)
-					lastLog = time.Now().Unix()
-				}
-
-				lines = make([]string, 0)
-			}
-		}
+	ReadProgress(pipe, func(report *models.ProgressReport) bool {
+		lastReport = report
 
-		if err != nil && err != io.EOF && err != os.ErrClosed && !strings.HasSuffix(err.Error(), "file already closed") {
-			log.Errorf("Error reading stdout: %s", err)
-			return
+		if ShouldExitEarly(job, sizeLimit, report) {
+			stopTranscoder <- true
+			return false
 		}
-	}
-}
 
-func ReadError(pipe io.ReadCloser) {
-	for {
-		buffer := make([]byte, 1)
-		readCount, err := pipe.Read(buffer)
-
-		if readCount == 0 {
-			break
-		}
+		notifications.NotifyProgressStatus(report)
 
-		if err != nil && err != io.EOF && err != os.ErrClosed && !strings.HasSuffix(err.Error(), "file already closed") {
-			log.Errorf("Error reading stderr: %s", err)
-			return
+		if time.Now().Unix()-lastLog > int64(viper.GetInt("interval")) {
+			report.Log(job.FileName)
+			lastLog = time.Now().Unix()
 		}
 
-		_, err = os.Stderr.Write(buffer)
+		return true
+	})
+}
 
-		if err != nil {
-			log.Errorf("Error writing to stderr: %s", err)
-			return
-		}
+// ShouldExitEarly decides whether the output is going to end up too large to be kept
+func ShouldExitEarly(job *Job, sizeLimit int64, report *models.ProgressReport) bool {
+	if !viper.GetBool("early-exit") || !viper.GetBool("keep-old") {
+		return false
 	}
-}
 
-var flatParseRegex = regexp.MustCompile(`\s*(-?[0-9.]+).*`)
-
-func OutputToReport(lines []string) *models.ProgressReport {
-	report := models.ProgressReport{}
-
-	for _, line := range lines {
-		split := strings.Split(line, "=")
-		switch split[0] {
-		case "frame":
-			report.Frame, _ = strconv.Atoi(split[1])
-			break
-		case "fps":
-			report.FPS, _ = strconv.ParseFloat(split[1], 64)
-			break
-		case "bitrate":
-			matches := flatParseRegex.FindAllStringSubmatch(split[1], -1)
-			if len(matches) > 0 {
-				report.Bitrate, _ = strconv.ParseFloat(matches[0][1], 64)
-			}
-			break
-		case "total_size":
-			report.TotalSize, _ = strconv.Atoi(split[1])
-			break
-		case "speed":
-			matches := flatParseRegex.FindAllStringSubmatch(split[1], -1)
-			if len(matches) > 0 {
-				report.Speed, _ = strconv.ParseFloat(matches[0][1], 64)
-			}
-			break
-		case "progress":
-			report.Progress = split[1]
-			break
-		}
+	if int64(report.TotalSize) > sizeLimit {
+		return true
 	}
 
-	return &report
+	return SkipConfidence(job, sizeLimit, report) > viper.GetFloat64("skip-confidence")
 }
 
 func HookTermination(c *exec.Cmd, stopTranscoder chan bool, done chan bool, tempFileName string) {
@@ -251,19 +380,20 @@ func HookTermination(c *exec.Cmd, stopTranscoder chan bool, done chan bool, temp
 		if toTerminate {
 			err := c.Process.Kill()
 
-			if err != nil {
+			if err != nil && !errors.Is(err, os.ErrProcessDone) {
 				log.Errorf("Error killing process: %s", err)
 			}
 
 			_, err = c.Process.Wait()
 
 			if err != nil {
-				log.Errorf("Error waiting for process exit: %s", err)
+				// c.Wait may have reaped the process first
+				log.Debugf("Error waiting for process exit: %s", err)
 			}
 
 			err = os.Remove(tempFileName)
 
-			if err != nil {
+			if err != nil && !os.IsNotExist(err) {
 				log.Errorf("Error deleting file %s: %s", tempFileName, err)
 			}
 
@@ -273,7 +403,7 @@ func HookTermination(c *exec.Cmd, stopTranscoder chan bool, done chan bool, temp
 		done <- toTerminate
 	}()
 
-	terminate := make(chan os.Signal)
+	terminate := make(chan os.Signal, 1)
 
 	go func() {
 		toTerminate := <-terminate
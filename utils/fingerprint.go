@@ -0,0 +1,57 @@
+package utils
+
+import (
+	"github.com/cespare/xxhash/v2"
+	"io"
+	"os"
+	"strconv"
+)
+
+const fingerprintChunk = 1 << 20
+
+// Fingerprint hashes the head, middle and tail of a file together with its size.
+// It is cheap on large files and network shares, yet tells apart files of the same size.
+func Fingerprint(fileName string) (string, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return "", err
+	}
+
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	size := stat.Size()
+	hash := xxhash.New()
+	buffer := make([]byte, fingerprintChunk)
+
+	offsets := []int64{0}
+	if size > 3*fingerprintChunk {
+		offsets = append(offsets, size/2-fingerprintChunk/2, size-fingerprintChunk)
+	}
+
+	for _, offset := range offsets {
+		read, err := file.ReadAt(buffer, offset)
+		if err != nil && err != io.EOF {
+			return "", err
+		}
+
+		_, _ = hash.Write(buffer[:read])
+	}
+
+	if size <= 3*fingerprintChunk && size > fingerprintChunk {
+		// Small files are hashed completely
+		if _, err := file.Seek(fingerprintChunk, io.SeekStart); err != nil {
+			return "", err
+		}
+
+		if _, err := io.Copy(hash, file); err != nil {
+			return "", err
+		}
+	}
+
+	return strconv.FormatInt(size, 10) + ":" + strconv.FormatUint(hash.Sum64(), 16), nil
+}
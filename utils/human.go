@@ -1,6 +1,11 @@
 package utils
 
-import "fmt"
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
 
 func BytesHumanReadable(b int64) string {
 	const unit = 1000
@@ -14,3 +19,35 @@ func BytesHumanReadable(b int64) string {
 	}
 	return fmt.Sprintf("%.1f %cB", float64(b)/float64(div), "kMGTPE"[exp])
 }
+
+func BitsHumanReadable(b int64) string {
+	return strings.TrimSuffix(BytesHumanReadable(b), "B") + "bps"
+}
+
+// ParseHumanSize parses sizes and bitrates such as 500k, 4M or 2.5G using the same 1000 based units as BytesHumanReadable
+func ParseHumanSize(value string) (int64, error) {
+	value = strings.TrimSpace(value)
+	value = strings.TrimSpace(strings.TrimSuffix(strings.TrimSuffix(value, "B"), "b"))
+
+	if value == "" {
+		return 0, fmt.Errorf("empty size")
+	}
+
+	unit := value[len(value)-1]
+	if unit == 'K' {
+		unit = 'k'
+	}
+
+	multiplier := float64(1)
+	if exp := strings.IndexByte("kMGTPE", unit); exp >= 0 {
+		multiplier = math.Pow(1000, float64(exp+1))
+		value = strings.TrimSpace(value[:len(value)-1])
+	}
+
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %s", value)
+	}
+
+	return int64(number * multiplier), nil
+}
@@ -0,0 +1,61 @@
+package utils
+
+import (
+	"testing"
+)
+
+func TestParseHumanSize(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected int64
+		ok       bool
+	}{
+		{value: "500", expected: 500, ok: true},
+		{value: "500k", expected: 500000, ok: true},
+		{value: "500K", expected: 500000, ok: true},
+		{value: "4M", expected: 4000000, ok: true},
+		{value: "2.5G", expected: 2500000000, ok: true},
+		{value: "1TB", expected: 1000000000000, ok: true},
+		{value: " 8Mb ", expected: 8000000, ok: true},
+		{value: "", ok: false},
+		{value: "B", ok: false},
+		{value: "fast", ok: false},
+		{value: "4X", ok: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			actual, err := ParseHumanSize(test.value)
+			if (err == nil) != test.ok {
+				t.Fatalf("expected ok %v, got error %v", test.ok, err)
+			}
+
+			if actual != test.expected {
+				t.Errorf("expected %d, got %d", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestBytesHumanReadable(t *testing.T) {
+	tests := []struct {
+		bytes    int64
+		expected string
+	}{
+		{bytes: 999, expected: "999 B"},
+		{bytes: 1500, expected: "1.5 kB"},
+		{bytes: 2500000000, expected: "2.5 GB"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.expected, func(t *testing.T) {
+			if actual := BytesHumanReadable(test.bytes); actual != test.expected {
+				t.Errorf("expected %s, got %s", test.expected, actual)
+			}
+
+			if parsed, err := ParseHumanSize(test.expected); err != nil || parsed != test.bytes {
+				t.Errorf("expected %s to parse back to %d, got %d", test.expected, test.bytes, parsed)
+			}
+		})
+	}
+}
@@ -1,24 +1,26 @@
 package utils
 
 import (
-	"github.com/Vilsol/transcoder-go/models"
 	"math"
 )
 
 func SkipConfidence(originalSize int, currentSize int, completion float64) float64 {
-	expectedSize := float64(currentSize*100) / completion
-	sizeDiff := ((expectedSize / float64(originalSize)) - 1) * 100
+	return ExpectedSkipConfidence(float64(originalSize), float64(currentSize*100)/completion, completion)
+}
+
+// ExpectedSkipConfidence grows with how far the expected size exceeds the limit and with completion
+func ExpectedSkipConfidence(limit float64, expectedSize float64, completion float64) float64 {
+	sizeDiff := ((expectedSize / limit) - 1) * 100
 	if sizeDiff > 10 {
 		return math.Log(sizeDiff) / math.Log(3-math.Log10(completion))
 	}
 	return 0
 }
 
-func SkipConfidenceMeta(originalMetadata *models.FileMetadata, frame int, size int) float64 {
-	complete := (float64(frame) / float64(originalMetadata.Frames())) * 100
-
-	if (float64(frame)/float64(originalMetadata.Frames()))*100 > 25 {
-		return SkipConfidence(int(originalMetadata.Format.SizeInt()), size, complete)
+// SkipConfidenceAt extrapolates the final size once more than a quarter of the file is done
+func SkipConfidenceAt(sizeLimit int64, size int, completion float64) float64 {
+	if completion > 25 {
+		return SkipConfidence(int(sizeLimit), size, completion)
 	}
 
 	return 0